@@ -0,0 +1,95 @@
+package apextest
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Node is a telemetry item in the tree of an operation, whose children are
+// the telemetry items that have it as their parent.
+type Node struct {
+	// Id is the id of the telemetry item, if it has one.
+	Id string
+	// ParentId is the operation parent id of the telemetry item.
+	ParentId string
+	// Name is the name of the telemetry item, if it has one.
+	Name string
+	// Envelope is the telemetry item.
+	Envelope Envelope
+	// Children are the telemetry items that have the item as their parent,
+	// ordered by time.
+	Children []*Node
+}
+
+// Operation reconstructs the tree of telemetry items of the operation from
+// their ids and parent ids, and returns its roots ordered by time. Items
+// whose parent was not received are roots.
+func (s *Server) Operation(operationId string) []*Node {
+	nodes := []*Node{}
+	byId := map[string]*Node{}
+	for _, e := range s.Envelopes() {
+		if e.Tags.Operation().GetId() != operationId {
+			continue
+		}
+		data := struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		}{}
+		json.Unmarshal(e.BaseData, &data)
+
+		n := &Node{
+			Id:       data.Id,
+			ParentId: e.Tags.Operation().GetParentId(),
+			Name:     data.Name,
+			Envelope: e,
+		}
+		nodes = append(nodes, n)
+		if n.Id != "" {
+			byId[n.Id] = n
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return parseTime(nodes[i].Envelope.Time).
+			Before(parseTime(nodes[j].Envelope.Time))
+	})
+
+	roots := []*Node{}
+	for _, n := range nodes {
+		if p, ok := byId[n.ParentId]; ok && p != n {
+			p.Children = append(p.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	return roots
+}
+
+// parseTime parses the time of a telemetry item, which has a variable
+// number of decimal seconds digits.
+func parseTime(val string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, val)
+	return t
+}
+
+// String formats the tree of the node with one item per line, indented by
+// its depth, as its base type and name.
+func (n *Node) String() string {
+	sb := strings.Builder{}
+	n.format(&sb, 0)
+	return sb.String()
+}
+
+// format writes the tree of the node to the builder at the depth.
+func (n *Node) format(sb *strings.Builder, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(n.Envelope.BaseType)
+	if n.Name != "" {
+		sb.WriteString(" " + n.Name)
+	}
+	sb.WriteString("\n")
+	for _, c := range n.Children {
+		c.format(sb, depth+1)
+	}
+}
@@ -0,0 +1,89 @@
+package apextest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Soreing/apex"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestOperation tests that the tree of an operation is reconstructed from
+// the parent ids of its telemetry items
+func TestOperation(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	exp, tp := newTestExporter(t, srv)
+	tracer := tp.Tracer("test")
+
+	ctx, req := tracer.Start(
+		context.Background(), "GET /checkout",
+		trace.WithSpanKind(trace.SpanKindServer),
+	)
+	cctx, pay := tracer.Start(ctx, "POST /payments", trace.WithSpanKind(trace.SpanKindClient))
+	exp.TrackEvent(cctx, "payment sent", nil, nil)
+	time.Sleep(time.Millisecond)
+	pay.SetStatus(codes.Ok, "")
+	pay.End()
+	_, ship := tracer.Start(ctx, "POST /shipments", trace.WithSpanKind(trace.SpanKindClient))
+	ship.SetStatus(codes.Ok, "")
+	ship.End()
+	req.SetStatus(codes.Ok, "")
+	req.End()
+
+	_, other := tracer.Start(context.Background(), "GET /health", trace.WithSpanKind(trace.SpanKindServer))
+	other.End()
+
+	assert.NoError(t, exp.Shutdown(context.Background()))
+	assert.True(t, srv.Wait(5, time.Second))
+
+	roots := srv.Operation(req.SpanContext().TraceID().String())
+	assert.Equal(t, 1, len(roots))
+	assert.Equal(t, req.SpanContext().SpanID().String(), roots[0].Id)
+	assert.Equal(t, ""+
+		"RequestData GET /checkout\n"+
+		"  RemoteDependencyData POST /payments\n"+
+		"    EventData payment sent\n"+
+		"  RemoteDependencyData POST /shipments\n",
+		roots[0].String(),
+	)
+
+	assert.Equal(t, 0, len(srv.Operation("00000000000000000000000000000000")))
+}
+
+// TestOperationPageView tests that spans created within a page view are
+// children of the page view
+func TestOperationPageView(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	exp, err := apex.NewExporterFromConnectionString(
+		srv.ConnectionString(), nil,
+		apex.WithPageViews(apex.Filter{Names: []string{"render *"}}),
+	)
+	assert.NoError(t, err)
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	tracer := tp.Tracer("test")
+
+	ctx, page := tracer.Start(context.Background(), "render /home")
+	_, dep := tracer.Start(ctx, "GET /profile", trace.WithSpanKind(trace.SpanKindClient))
+	dep.SetStatus(codes.Ok, "")
+	dep.End()
+	page.SetStatus(codes.Ok, "")
+	page.End()
+
+	assert.NoError(t, exp.Shutdown(context.Background()))
+	assert.True(t, srv.Wait(2, time.Second))
+
+	roots := srv.Operation(page.SpanContext().TraceID().String())
+	assert.Equal(t, 1, len(roots))
+	assert.Equal(t, page.SpanContext().SpanID().String(), roots[0].Id)
+	assert.Equal(t, ""+
+		"PageViewData render /home\n"+
+		"  RemoteDependencyData GET /profile\n",
+		roots[0].String(),
+	)
+}
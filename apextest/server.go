@@ -0,0 +1,324 @@
+// Package apextest provides a local Application Insights ingestion server
+// for testing instrumentation exported with apex end to end, without
+// sending telemetry to Azure.
+package apextest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// InstrumentationKey is the instrumentation key in the server's connection
+// string.
+const InstrumentationKey = "00000000-0000-0000-0000-000000000000"
+
+// Base types of the telemetry items.
+const (
+	RequestType      = "RequestData"
+	DependencyType   = "RemoteDependencyData"
+	EventType        = "EventData"
+	ExceptionType    = "ExceptionData"
+	AvailabilityType = "AvailabilityData"
+	PageViewType     = "PageViewData"
+)
+
+// Envelope is a telemetry item received by the server.
+type Envelope struct {
+	// Name is the type name of the telemetry item.
+	Name string
+	// Time is the time when the telemetry item was created.
+	Time string
+	// IKey is the instrumentation key of the telemetry item.
+	IKey string
+	// SampleRate is the sampling percentage of the telemetry item.
+	SampleRate float64
+	// Tags are the context tags of the telemetry item, such as the
+	// operation and the cloud role.
+	Tags contracts.ContextTags
+	// BaseType is the type of the telemetry data, such as "RequestData".
+	BaseType string
+	// BaseData is the encoded telemetry data.
+	BaseData json.RawMessage
+}
+
+// Request is a request telemetry item received by the server.
+type Request struct {
+	contracts.RequestData
+	Tags contracts.ContextTags
+}
+
+// Dependency is a dependency telemetry item received by the server.
+type Dependency struct {
+	contracts.RemoteDependencyData
+	Tags contracts.ContextTags
+}
+
+// Event is an event telemetry item received by the server.
+type Event struct {
+	contracts.EventData
+	Tags contracts.ContextTags
+}
+
+// Exception is an exception telemetry item received by the server.
+type Exception struct {
+	contracts.ExceptionData
+	Tags contracts.ContextTags
+}
+
+// Server is a local ingestion server that accepts and stores telemetry
+// items. Exporters are pointed to the server with its connection string.
+type Server struct {
+	*httptest.Server
+	mtx       sync.Mutex
+	envelopes []Envelope
+	received  chan struct{}
+}
+
+// NewServer starts an ingestion server on a local port. The server should
+// be closed when the test ends.
+func NewServer() *Server {
+	srv := &Server{received: make(chan struct{}, 1)}
+	srv.Server = httptest.NewServer(http.HandlerFunc(srv.handle))
+	return srv
+}
+
+// ConnectionString returns a connection string whose ingestion and live
+// endpoints are the server.
+func (s *Server) ConnectionString() string {
+	return "InstrumentationKey=" + InstrumentationKey +
+		";IngestionEndpoint=" + s.URL +
+		";LiveEndpoint=" + s.URL
+}
+
+// handle accepts batches of telemetry on the track endpoint. Live metrics
+// requests are acknowledged without subscribing to metrics.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.URL.Path, "/QuickPulseService.svc/") {
+		w.Header().Set("x-ms-qps-subscribed", "false")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/track") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	envelopes, err := decode(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mtx.Lock()
+	s.envelopes = append(s.envelopes, envelopes...)
+	s.mtx.Unlock()
+	select {
+	case s.received <- struct{}{}:
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"itemsReceived": len(envelopes),
+		"itemsAccepted": len(envelopes),
+		"errors":        []interface{}{},
+	})
+}
+
+// decode reads the newline delimited telemetry items of the request body,
+// which may be compressed with gzip.
+func decode(r *http.Request) ([]Envelope, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	envelopes := []Envelope{}
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		raw := struct {
+			Name       string            `json:"name"`
+			Time       string            `json:"time"`
+			IKey       string            `json:"iKey"`
+			SampleRate float64           `json:"sampleRate"`
+			Tags       map[string]string `json:"tags"`
+			Data       struct {
+				BaseType string          `json:"baseType"`
+				BaseData json.RawMessage `json:"baseData"`
+			} `json:"data"`
+		}{}
+		if err := json.Unmarshal(line, &raw); err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, Envelope{
+			Name:       raw.Name,
+			Time:       raw.Time,
+			IKey:       raw.IKey,
+			SampleRate: raw.SampleRate,
+			Tags:       contracts.ContextTags(raw.Tags),
+			BaseType:   raw.Data.BaseType,
+			BaseData:   raw.Data.BaseData,
+		})
+	}
+	return envelopes, sc.Err()
+}
+
+// Envelopes returns the telemetry items received so far, in the order they
+// were received.
+func (s *Server) Envelopes() []Envelope {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]Envelope{}, s.envelopes...)
+}
+
+// Reset discards the telemetry items received so far.
+func (s *Server) Reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.envelopes = nil
+}
+
+// Wait waits until at least n telemetry items were received, and reports
+// whether they were received before the timeout. Exporters send telemetry
+// in batches, so flushing or shutting down the exporter is faster than
+// waiting for the batch interval.
+func (s *Server) Wait(n int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mtx.Lock()
+		count := len(s.envelopes)
+		s.mtx.Unlock()
+		if count >= n {
+			return true
+		}
+		select {
+		case <-s.received:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// Requests returns the request telemetry items received so far.
+func (s *Server) Requests() []Request {
+	items := []Request{}
+	for _, e := range s.ofType(RequestType) {
+		item := Request{Tags: e.Tags}
+		if json.Unmarshal(e.BaseData, &item.RequestData) == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Dependencies returns the dependency telemetry items received so far.
+func (s *Server) Dependencies() []Dependency {
+	items := []Dependency{}
+	for _, e := range s.ofType(DependencyType) {
+		item := Dependency{Tags: e.Tags}
+		if json.Unmarshal(e.BaseData, &item.RemoteDependencyData) == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Events returns the event telemetry items received so far.
+func (s *Server) Events() []Event {
+	items := []Event{}
+	for _, e := range s.ofType(EventType) {
+		item := Event{Tags: e.Tags}
+		if json.Unmarshal(e.BaseData, &item.EventData) == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Exceptions returns the exception telemetry items received so far.
+func (s *Server) Exceptions() []Exception {
+	items := []Exception{}
+	for _, e := range s.ofType(ExceptionType) {
+		item := Exception{Tags: e.Tags}
+		if json.Unmarshal(e.BaseData, &item.ExceptionData) == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// FindRequest returns the first request received with the name.
+func (s *Server) FindRequest(name string) (Request, bool) {
+	for _, r := range s.Requests() {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Request{}, false
+}
+
+// FindDependency returns the first dependency received with the name.
+func (s *Server) FindDependency(name string) (Dependency, bool) {
+	for _, d := range s.Dependencies() {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Dependency{}, false
+}
+
+// FindDependencyByTarget returns the first dependency received with the
+// target. Targets with an application id, like "host | cid-v1:id", also
+// match the host alone.
+func (s *Server) FindDependencyByTarget(target string) (Dependency, bool) {
+	for _, d := range s.Dependencies() {
+		host, _, _ := strings.Cut(d.Target, " | ")
+		if d.Target == target || host == target {
+			return d, true
+		}
+	}
+	return Dependency{}, false
+}
+
+// FindEvent returns the first event received with the name.
+func (s *Server) FindEvent(name string) (Event, bool) {
+	for _, e := range s.Events() {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return Event{}, false
+}
+
+// ofType returns the telemetry items received so far of the base type.
+func (s *Server) ofType(baseType string) []Envelope {
+	items := []Envelope{}
+	for _, e := range s.Envelopes() {
+		if e.BaseType == baseType {
+			items = append(items, e)
+		}
+	}
+	return items
+}
@@ -0,0 +1,123 @@
+package apextest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Soreing/apex"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTestExporter creates an exporter that sends telemetry to the server
+// and a tracer provider that exports spans with it.
+func newTestExporter(
+	t *testing.T,
+	srv *Server,
+) (*apex.AppInsightsExporter, *sdktrace.TracerProvider) {
+	exp, err := apex.NewExporterFromConnectionString(srv.ConnectionString(), nil)
+	assert.NoError(t, err)
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	return exp, tp
+}
+
+// TestServer tests that telemetry exported to the server is decoded into
+// requests, dependencies and events that can be found
+func TestServer(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	exp, tp := newTestExporter(t, srv)
+
+	tracer := tp.Tracer("test")
+	ctx, req := tracer.Start(
+		context.Background(), "GET /orders",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("url", "http://shop/orders")),
+	)
+	_, dep := tracer.Start(
+		ctx, "SELECT orders",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.NetPeerNameKey.String("db.internal"),
+		),
+	)
+	dep.SetStatus(codes.Ok, "")
+	dep.End()
+	exp.TrackEvent(ctx, "order listed", map[string]string{"count": "3"}, nil)
+	req.SetStatus(codes.Ok, "")
+	req.End()
+
+	assert.NoError(t, exp.Shutdown(context.Background()))
+	assert.True(t, srv.Wait(3, time.Second))
+	assert.Equal(t, 3, len(srv.Envelopes()))
+	assert.Equal(t, InstrumentationKey, srv.Envelopes()[0].IKey)
+
+	r, ok := srv.FindRequest("GET /orders")
+	assert.True(t, ok)
+	assert.Equal(t, "http://shop/orders", r.Url)
+	assert.True(t, r.Success)
+	assert.Equal(t, req.SpanContext().TraceID().String(), r.Tags.Operation().GetId())
+
+	d, ok := srv.FindDependencyByTarget("db.internal")
+	assert.True(t, ok)
+	assert.Equal(t, "SELECT orders", d.Name)
+	assert.Equal(t, "SQL", d.Type)
+	assert.Equal(t, r.Id, d.Tags.Operation().GetParentId())
+
+	e, ok := srv.FindEvent("order listed")
+	assert.True(t, ok)
+	assert.Equal(t, "3", e.Properties["count"])
+
+	_, ok = srv.FindRequest("GET /users")
+	assert.False(t, ok)
+	_, ok = srv.FindDependency("SELECT users")
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(srv.Exceptions()))
+
+	srv.Reset()
+	assert.Equal(t, 0, len(srv.Envelopes()))
+}
+
+// TestServerTarget tests that dependencies are found by their host when the
+// target has an application id
+func TestServerTarget(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	body := bytes.Buffer{}
+	zw := gzip.NewWriter(&body)
+	zw.Write([]byte(`{"name":"dep","iKey":"key","data":{"baseType":"RemoteDependencyData",` +
+		`"baseData":{"name":"GET /","target":"api:443 | cid-v1:app"}}}` + "\n"))
+	zw.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v2.1/track", &body)
+	req.Header.Set("Content-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	_, ok := srv.FindDependencyByTarget("api:443")
+	assert.True(t, ok)
+	_, ok = srv.FindDependencyByTarget("api:443 | cid-v1:app")
+	assert.True(t, ok)
+	_, ok = srv.FindDependencyByTarget("api")
+	assert.False(t, ok)
+}
+
+// TestServerWait tests that waiting for telemetry times out when too few
+// items are received
+func TestServerWait(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	assert.False(t, srv.Wait(1, 10*time.Millisecond))
+	assert.True(t, srv.Wait(0, 10*time.Millisecond))
+}
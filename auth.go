@@ -0,0 +1,78 @@
+package apex
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// IngestionScope is the scope of the Azure Monitor audience that tokens used
+// for authenticated ingestion must be issued for.
+const IngestionScope = "https://monitor.azure.com//.default"
+
+// tokenRefreshMargin is how long before its expiry a cached token is
+// refreshed.
+const tokenRefreshMargin = 5 * time.Minute
+
+// AccessToken is a bearer token and the time it expires.
+type AccessToken struct {
+	Token     string
+	ExpiresOn time.Time
+}
+
+// TokenSource provides Microsoft Entra ID bearer tokens for the Azure Monitor
+// audience, used to authenticate ingestion requests.
+type TokenSource interface {
+	Token(ctx context.Context) (AccessToken, error)
+}
+
+// TokenSourceFunc is an adapter to use a function as a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (AccessToken, error)
+
+// Token returns a token by calling the function.
+func (f TokenSourceFunc) Token(ctx context.Context) (AccessToken, error) {
+	return f(ctx)
+}
+
+// tokenCache caches the token of a token source until it is about to expire.
+type tokenCache struct {
+	source TokenSource
+	mtx    sync.Mutex
+	token  AccessToken
+}
+
+// newTokenCache creates a token cache for the token source.
+func newTokenCache(source TokenSource) *tokenCache {
+	return &tokenCache{source: source}
+}
+
+// get returns the cached token, or a new token from the token source if the
+// cached token is missing or about to expire.
+func (tc *tokenCache) get(ctx context.Context) (string, error) {
+	tc.mtx.Lock()
+	defer tc.mtx.Unlock()
+
+	if tc.token.Token != "" &&
+		time.Until(tc.token.ExpiresOn) > tokenRefreshMargin {
+		return tc.token.Token, nil
+	}
+
+	tok, err := tc.source.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	if tok.Token == "" {
+		return "", errors.New("token source returned an empty token")
+	}
+	tc.token = tok
+	return tok.Token, nil
+}
+
+// invalidate discards the cached token so that the next one is requested
+// from the token source.
+func (tc *tokenCache) invalidate() {
+	tc.mtx.Lock()
+	defer tc.mtx.Unlock()
+	tc.token = AccessToken{}
+}
@@ -0,0 +1,116 @@
+package apex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// newTestTokenSource creates a token source that issues numbered tokens
+// valid for the duration, and counts how many tokens were issued.
+func newTestTokenSource(valid time.Duration, issued *atomic.Int64) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (AccessToken, error) {
+		n := issued.Add(1)
+		return AccessToken{
+			Token:     fmt.Sprintf("token-%d", n),
+			ExpiresOn: time.Now().Add(valid),
+		}, nil
+	})
+}
+
+// TestTokenCache tests that tokens are cached until they are about to expire
+// or are invalidated
+func TestTokenCache(t *testing.T) {
+	issued := atomic.Int64{}
+	tc := newTokenCache(newTestTokenSource(time.Hour, &issued))
+
+	tok, err := tc.get(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "token-1", tok)
+
+	tok, _ = tc.get(context.Background())
+	assert.Equal(t, "token-1", tok)
+
+	tc.invalidate()
+	tok, _ = tc.get(context.Background())
+	assert.Equal(t, "token-2", tok)
+
+	short := newTokenCache(newTestTokenSource(time.Minute, &issued))
+	tok, _ = short.get(context.Background())
+	assert.Equal(t, "token-3", tok)
+	tok, _ = short.get(context.Background())
+	assert.Equal(t, "token-4", tok)
+
+	failing := newTokenCache(TokenSourceFunc(
+		func(ctx context.Context) (AccessToken, error) {
+			return AccessToken{}, errors.New("unavailable")
+		},
+	))
+	_, err = failing.get(context.Background())
+	assert.NotNil(t, err)
+
+	empty := newTokenCache(TokenSourceFunc(
+		func(ctx context.Context) (AccessToken, error) {
+			return AccessToken{ExpiresOn: time.Now().Add(time.Hour)}, nil
+		},
+	))
+	_, err = empty.get(context.Background())
+	assert.NotNil(t, err)
+}
+
+// TestAuthenticatedIngestion tests that the exporter authenticates ingestion
+// requests and requests a new token when the token is rejected
+func TestAuthenticatedIngestion(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			if n == 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+	defer srv.Close()
+
+	issued := atomic.Int64{}
+	cfg := appinsights.NewTelemetryConfiguration("")
+	cfg.EndpointUrl = srv.URL
+	exp, err := NewExporterFromConfig(
+		cfg, nil, WithTokenSource(newTestTokenSource(time.Hour, &issued)),
+	)
+	assert.Nil(t, err)
+	exp.client.Channel().(*batchChannel).backoff = []time.Duration{
+		time.Millisecond,
+	}
+
+	res, _ := resource.New(context.Background())
+	spans := []sdktrace.ReadOnlySpan{
+		&mockSpan{
+			name:   "span",
+			kind:   trace.SpanKindServer,
+			status: sdktrace.Status{Code: codes.Ok},
+			res:    res,
+			attr:   []attribute.KeyValue{},
+		},
+	}
+
+	assert.Nil(t, exp.ExportSpans(context.Background(), spans))
+	assert.Nil(t, exp.Shutdown(context.Background()))
+
+	assert.Equal(t, 2, len(srv.requests))
+	assert.Equal(t, "Bearer token-1", srv.requests[0].Header.Get("Authorization"))
+	assert.Equal(t, "Bearer token-2", srv.requests[1].Header.Get("Authorization"))
+	assert.Equal(t, int64(2), issued.Load())
+}
@@ -0,0 +1,142 @@
+package apex
+
+import (
+	"errors"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// Attributes that tag spans as availability tests.
+const (
+	availabilityKey         = "availability"
+	availabilityLocationKey = "availability.location"
+)
+
+// Availability is the result of a run of an availability test, such as a
+// synthetic probe of an endpoint, shown in the Availability blade.
+type Availability struct {
+	// Id identifies the test run. A random id is generated if it is empty.
+	Id string
+	// Name is the name of the test.
+	Name string
+	// RunLocation is the name of the location where the test was run.
+	RunLocation string
+	// Timestamp is the time when the test run started. The default is the
+	// current time minus the duration.
+	Timestamp time.Time
+	// Duration is the duration of the test run.
+	Duration time.Duration
+	// Success reports whether the test passed.
+	Success bool
+	// Message is a diagnostic message for the result, such as the reason of
+	// a failure.
+	Message string
+	// Properties are custom properties of the result.
+	Properties map[string]string
+	// Measurements are custom measurements of the result.
+	Measurements map[string]float64
+}
+
+// AvailabilityTest returns the attributes that tag a span as a run of an
+// availability test in the location. The exporter maps tagged spans to
+// availability results named after the span, which pass when the span's
+// status is ok and have the status description as their message.
+func AvailabilityTest(location string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Bool(availabilityKey, true),
+		attribute.String(availabilityLocationKey, location),
+	}
+}
+
+// isAvailabilityTest checks if the span is tagged as an availability test.
+func isAvailabilityTest(sp sdktrace.ReadOnlySpan) bool {
+	return spanAttribute(sp, availabilityKey) == "true"
+}
+
+// TrackAvailability sends the result of an availability test run to
+// Application Insights. Results can not be tracked after the exporter is
+// shut down.
+func (exp *AppInsightsExporter) TrackAvailability(result Availability) error {
+	exp.mtx.RLock()
+	defer exp.mtx.RUnlock()
+	if exp.closed {
+		return errors.New("exporter closed")
+	}
+
+	tele := appinsights.NewAvailabilityTelemetry(
+		result.Name, result.Duration, result.Success,
+	)
+	tele.Id = result.Id
+	if tele.Id == "" {
+		tele.Id = newSpanId()
+	}
+	tele.RunLocation = result.RunLocation
+	tele.Message = result.Message
+	tele.Timestamp = result.Timestamp
+	if tele.Timestamp.IsZero() {
+		tele.Timestamp = time.Now().Add(-result.Duration)
+	}
+	for k, v := range result.Properties {
+		tele.Properties[k] = v
+	}
+	for k, v := range result.Measurements {
+		tele.Measurements[k] = v
+	}
+	tele.Tags.Cloud().SetRole(exp.serviceName)
+
+	exp.track(tele)
+	return nil
+}
+
+// processAvailability constructs the telemetry for a span tagged as an
+// availability test and dispatches it to the application insights
+// telemetry client.
+//
+// Application Insights specific fields are sourced from custom properties:
+// Role = properties["service.name"]
+// RunLocation = properties["availability.location"]
+// Message = the description of the span's status
+func (exp *AppInsightsExporter) processAvailability(
+	sp sdktrace.ReadOnlySpan,
+	success bool,
+	properties map[string]string,
+	measurements map[string]float64,
+) {
+	tele := appinsights.AvailabilityTelemetry{
+		Name:     sp.Name(),
+		Id:       sp.SpanContext().SpanID().String(),
+		Duration: sp.EndTime().Sub(sp.StartTime()),
+		Success:  success,
+		Message:  sp.Status().Description,
+		BaseTelemetry: appinsights.BaseTelemetry{
+			Timestamp:  sp.StartTime(),
+			Tags:       make(contracts.ContextTags),
+			Properties: map[string]string{},
+		},
+		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
+			Measurements: measurements,
+		},
+	}
+	tele.Tags.Cloud().SetRole(exp.serviceName)
+	if val, ok := properties[string(semconv.ServiceNameKey)]; ok {
+		delete(properties, string(semconv.ServiceNameKey))
+		tele.Tags.Cloud().SetRole(val)
+	}
+	delete(properties, availabilityKey)
+	if val, ok := properties[availabilityLocationKey]; ok {
+		delete(properties, availabilityLocationKey)
+		tele.RunLocation = val
+	}
+	tele.BaseTelemetry.Properties = properties
+
+	tele.Tags.Operation().SetId(operationId(sp.SpanContext()))
+	tele.Tags.Operation().SetParentId(exp.parentId(sp))
+	tele.Tags.Operation().SetName(sp.Name())
+
+	exp.track(&tele)
+}
@@ -0,0 +1,227 @@
+package apex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestTrackAvailability tests that availability results are tracked with
+// their fields, and are rejected after the exporter is shut down
+func TestTrackAvailability(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		Name   string
+		Result Availability
+		Closed bool
+
+		Error        bool
+		TelTimestamp time.Time
+	}{
+		{
+			Name: "Successful test",
+			Result: Availability{
+				Id:           "run-1",
+				Name:         "homepage",
+				RunLocation:  "westeurope",
+				Timestamp:    now,
+				Duration:     time.Second,
+				Success:      true,
+				Properties:   map[string]string{"region": "eu"},
+				Measurements: map[string]float64{"bytes": 512},
+			},
+			TelTimestamp: now,
+		},
+		{
+			Name: "Failed test",
+			Result: Availability{
+				Name:      "checkout",
+				Timestamp: now,
+				Duration:  time.Second,
+				Success:   false,
+				Message:   "status code 503",
+			},
+			TelTimestamp: now,
+		},
+		{
+			Name:   "Closed exporter",
+			Result: Availability{Name: "homepage"},
+			Closed: true,
+			Error:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil, WithServiceName("probes"))
+			exp.client = tcl
+			exp.closed = test.Closed
+
+			err := exp.TrackAvailability(test.Result)
+			if test.Error {
+				assert.Error(t, err)
+				assert.Equal(t, 0, len(tcl.tels))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(tcl.tels))
+			assert.Equal(t, uint64(1), exp.Stats().AvailabilityTracked)
+
+			tel := tcl.tels[0].(*appinsights.AvailabilityTelemetry)
+			if test.Result.Id == "" {
+				assert.Regexp(t, "^[0-9a-f]{16}$", tel.Id)
+			} else {
+				assert.Equal(t, test.Result.Id, tel.Id)
+			}
+			assert.Equal(t, test.Result.Name, tel.Name)
+			assert.Equal(t, test.Result.RunLocation, tel.RunLocation)
+			assert.Equal(t, test.Result.Duration, tel.Duration)
+			assert.Equal(t, test.Result.Success, tel.Success)
+			assert.Equal(t, test.Result.Message, tel.Message)
+			assert.Equal(t, test.TelTimestamp, tel.Timestamp)
+			assert.Equal(t, "probes", tel.Tags.Cloud().GetRole())
+			for k, v := range test.Result.Properties {
+				assert.Equal(t, v, tel.Properties[k])
+			}
+			for k, v := range test.Result.Measurements {
+				assert.Equal(t, v, tel.Measurements[k])
+			}
+		})
+	}
+}
+
+// TestTrackAvailabilityTimestamp tests that results without a timestamp
+// start at the current time minus their duration
+func TestTrackAvailabilityTimestamp(t *testing.T) {
+	tcl := &mockTelemetryClient{}
+	exp, _ := NewExporter("", nil)
+	exp.client = tcl
+
+	before := time.Now()
+	exp.TrackAvailability(Availability{Name: "homepage", Duration: time.Minute})
+
+	tel := tcl.tels[0].(*appinsights.AvailabilityTelemetry)
+	assert.WithinDuration(t, before.Add(-time.Minute), tel.Timestamp, time.Second)
+}
+
+// TestProcessAvailability tests that spans tagged as availability tests are
+// mapped to availability telemetry regardless of their kind, and are kept
+// when sampling drops other spans
+func TestProcessAvailability(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		Name   string
+		Kind   trace.SpanKind
+		Status sdktrace.Status
+		Attr   []attribute.KeyValue
+
+		Availability   bool
+		TelSuccess     bool
+		TelMessage     string
+		TelRunLocation string
+		TelRole        string
+	}{
+		{
+			Name:   "Successful test",
+			Kind:   trace.SpanKindInternal,
+			Status: sdktrace.Status{Code: codes.Ok},
+			Attr: append(
+				AvailabilityTest("westeurope"),
+				semconv.ServiceNameKey.String("probes"),
+			),
+			Availability:   true,
+			TelSuccess:     true,
+			TelRunLocation: "westeurope",
+			TelRole:        "probes",
+		},
+		{
+			Name:           "Failed test",
+			Kind:           trace.SpanKindClient,
+			Status:         sdktrace.Status{Code: codes.Error, Description: "timeout"},
+			Attr:           AvailabilityTest("eastus"),
+			Availability:   true,
+			TelSuccess:     false,
+			TelMessage:     "timeout",
+			TelRunLocation: "eastus",
+			TelRole:        "unknown-service",
+		},
+		{
+			Name:   "String tag",
+			Kind:   trace.SpanKindServer,
+			Status: sdktrace.Status{Code: codes.Ok},
+			Attr: []attribute.KeyValue{
+				attribute.String("availability", "true"),
+			},
+			Availability: true,
+			TelSuccess:   true,
+			TelRole:      "unknown-service",
+		},
+		{
+			Name:   "Not a test",
+			Kind:   trace.SpanKindInternal,
+			Status: sdktrace.Status{Code: codes.Ok},
+			Attr: []attribute.KeyValue{
+				attribute.Bool("availability", false),
+			},
+			Availability: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil, WithSamplingPercentage(0))
+			exp.client = tcl
+
+			res, _ := resource.New(context.Background())
+			err := exp.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{
+				&mockSpan{
+					name:      "homepage",
+					kind:      test.Kind,
+					status:    test.Status,
+					startTime: start,
+					endTime:   start.Add(time.Second),
+					traceId:   [16]byte{1},
+					spanId:    [8]byte{2},
+					res:       res,
+					attr:      test.Attr,
+				},
+			})
+			assert.Nil(t, err)
+
+			if !test.Availability {
+				assert.Equal(t, 0, len(tcl.tels))
+				assert.Equal(t, uint64(1), exp.Stats().SpansSampledOut)
+				return
+			}
+			assert.Equal(t, 1, len(tcl.tels))
+			assert.Equal(t, uint64(0), exp.Stats().SpansSampledOut)
+			tel, ok := tcl.tels[0].(*appinsights.AvailabilityTelemetry)
+			assert.True(t, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, "homepage", tel.Name)
+			assert.Equal(t, "0200000000000000", tel.Id)
+			assert.Equal(t, time.Second, tel.Duration)
+			assert.Equal(t, start, tel.Timestamp)
+			assert.Equal(t, test.TelSuccess, tel.Success)
+			assert.Equal(t, test.TelMessage, tel.Message)
+			assert.Equal(t, test.TelRunLocation, tel.RunLocation)
+			assert.Equal(t, test.TelRole, tel.Tags.Cloud().GetRole())
+			assert.Equal(t, "01000000000000000000000000000000", tel.Tags.Operation().GetId())
+			assert.NotContains(t, tel.Properties, "availability")
+			assert.NotContains(t, tel.Properties, "availability.location")
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package apex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// defaultBackoff is the schedule of waits between attempts to send a batch
+// that failed transiently.
+var defaultBackoff = []time.Duration{
+	10 * time.Second,
+	30 * time.Second,
+	60 * time.Second,
+}
+
+// maxRejectionReasons limits the number of reasons for rejected items that
+// are logged for a batch.
+const maxRejectionReasons = 10
+
+// channelCounters holds the statistics of the items a channel transmits.
+type channelCounters struct {
+	itemsSent        atomic.Uint64
+	itemsRejected    atomic.Uint64
+	batchesDropped   atomic.Uint64
+	batchesPersisted atomic.Uint64
+}
+
+// batchChannel is a telemetry channel that buffers telemetry items and sends
+// them in batches with a transmitter. Items that fail transiently are retried
+// with a backoff, or with the delay requested by the ingestion endpoint, and
+// are persisted in the offline storage if they could not be sent.
+type batchChannel struct {
+	transmitter      *transmitter
+	store            *diskStore
+	logger           func(msg string) error
+	maxBatchSize     int
+	maxBatchInterval time.Duration
+	backoff          []time.Duration
+
+	items         chan []byte
+	flushes       chan struct{}
+	closing       chan struct{}
+	closeDeadline time.Time
+	stops         chan struct{}
+	done          chan struct{}
+	closeOnce     sync.Once
+	stopOnce      sync.Once
+	closed        chan struct{}
+	wg            sync.WaitGroup
+
+	throttledUntil atomic.Int64
+	replaying      atomic.Bool
+	counters       channelCounters
+}
+
+// newBatchChannel creates a batch channel and starts its processing loop. If
+// the channel has an offline storage, the persisted items are sent as well.
+func newBatchChannel(
+	tr *transmitter,
+	store *diskStore,
+	logger func(msg string) error,
+	maxBatchSize int,
+	maxBatchInterval time.Duration,
+) *batchChannel {
+	if maxBatchSize <= 0 {
+		maxBatchSize = 1024
+	}
+	if maxBatchInterval <= 0 {
+		maxBatchInterval = 10 * time.Second
+	}
+
+	ch := &batchChannel{
+		transmitter:      tr,
+		store:            store,
+		logger:           logger,
+		maxBatchSize:     maxBatchSize,
+		maxBatchInterval: maxBatchInterval,
+		backoff:          defaultBackoff,
+		items:            make(chan []byte, maxBatchSize),
+		flushes:          make(chan struct{}),
+		closing:          make(chan struct{}),
+		stops:            make(chan struct{}),
+		done:             make(chan struct{}),
+		closed:           make(chan struct{}),
+	}
+
+	go ch.run()
+	if store != nil {
+		ch.wg.Add(1)
+		go ch.replay()
+	}
+	return ch
+}
+
+// EndpointAddress returns the address of the ingestion endpoint.
+func (ch *batchChannel) EndpointAddress() string {
+	return ch.transmitter.endpoint
+}
+
+// Send serializes the telemetry item and queues it to be sent. Items sent
+// after the channel is closed or stopped are discarded.
+func (ch *batchChannel) Send(env *contracts.Envelope) {
+	select {
+	case <-ch.done:
+		ch.log("Telemetry item discarded; channel is closed")
+		return
+	default:
+	}
+
+	item, err := json.Marshal(env)
+	if err != nil {
+		ch.log("Telemetry item failed to serialize: " + err.Error())
+		return
+	}
+
+	select {
+	case ch.items <- item:
+	case <-ch.done:
+		ch.log("Telemetry item discarded; channel is closed")
+	}
+}
+
+// Flush sends the queued items without waiting for the batch to fill up.
+func (ch *batchChannel) Flush() {
+	select {
+	case ch.flushes <- struct{}{}:
+	case <-ch.done:
+	}
+}
+
+// Stop tears down the channel, discarding any items not sent yet.
+func (ch *batchChannel) Stop() {
+	ch.stopOnce.Do(func() {
+		close(ch.stops)
+	})
+}
+
+// IsThrottled checks if the ingestion endpoint asked the channel to wait
+// before sending more items.
+func (ch *batchChannel) IsThrottled() bool {
+	return time.Now().UnixNano() < ch.throttledUntil.Load()
+}
+
+// Close sends the queued items and tears down the channel. The returned
+// channel is closed when all items are sent, persisted or discarded. Failed
+// items, including the ones of batches sent before, are retried until the
+// retry timeout expires, or retried as usual if it is zero. Without a retry
+// timeout, failed items are not retried.
+func (ch *batchChannel) Close(retryTimeout ...time.Duration) <-chan struct{} {
+	deadline := time.Now()
+	if len(retryTimeout) > 0 {
+		if retryTimeout[0] == 0 {
+			deadline = time.Time{}
+		} else {
+			deadline = deadline.Add(retryTimeout[0])
+		}
+	}
+
+	ch.closeOnce.Do(func() {
+		ch.closeDeadline = deadline
+		close(ch.closing)
+		go func() {
+			<-ch.done
+			ch.wg.Wait()
+			close(ch.closed)
+		}()
+	})
+	return ch.closed
+}
+
+// run collects the queued items into batches, and dispatches the batches
+// when they are full, when the batch interval elapses or when requested.
+func (ch *batchChannel) run() {
+	defer close(ch.done)
+
+	batch := [][]byte{}
+	timer := time.NewTimer(ch.maxBatchInterval)
+	timer.Stop()
+
+	for {
+		select {
+		case item := <-ch.items:
+			batch = append(batch, item)
+			if len(batch) >= ch.maxBatchSize {
+				timer.Stop()
+				ch.dispatch(batch)
+				batch = [][]byte{}
+			} else if len(batch) == 1 {
+				timer.Reset(ch.maxBatchInterval)
+			}
+		case <-timer.C:
+			ch.dispatch(batch)
+			batch = [][]byte{}
+		case <-ch.flushes:
+			timer.Stop()
+			ch.dispatch(batch)
+			batch = [][]byte{}
+		case <-ch.closing:
+			timer.Stop()
+			for len(ch.items) > 0 {
+				batch = append(batch, <-ch.items)
+			}
+			ch.dispatch(batch)
+			return
+		case <-ch.stops:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// dispatch sends the batch in the background.
+func (ch *batchChannel) dispatch(batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+	ch.wg.Add(1)
+	go func() {
+		defer ch.wg.Done()
+		ch.send(batch)
+	}()
+}
+
+// send transmits the items and retries the ones that failed transiently
+// until the backoff schedule or the deadline of closing the channel is
+// exhausted. The items are held while the ingestion endpoint throttles the
+// channel. Items that could not be sent are persisted in the offline storage
+// if there is one.
+func (ch *batchChannel) send(items [][]byte) {
+	for attempt := 0; len(items) > 0; attempt++ {
+		if wait := ch.throttle(); wait > 0 && !ch.wait(wait) {
+			break
+		}
+
+		payload, err := compress(items)
+		if err != nil {
+			ch.log("Failed to compress telemetry: " + err.Error())
+			ch.counters.batchesDropped.Add(1)
+			return
+		}
+
+		tr, err := ch.transmitter.transmit(context.Background(), payload)
+		if err != nil {
+			ch.log("Failed to transmit telemetry: " + err.Error())
+		} else {
+			retry := tr.retryItems(items)
+			ch.observe(tr, items, retry)
+			items = retry
+			if len(items) == 0 {
+				if ch.store != nil && tr.statusCode < 300 {
+					ch.wg.Add(1)
+					go ch.replay()
+				}
+				return
+			}
+		}
+
+		if attempt >= len(ch.backoff) {
+			break
+		}
+		wait := ch.backoff[attempt]
+		if err == nil && !tr.retryAfter.IsZero() {
+			ch.throttledUntil.Store(tr.retryAfter.UnixNano())
+			wait = time.Until(tr.retryAfter)
+		}
+		if !ch.wait(wait) {
+			break
+		}
+	}
+
+	select {
+	case <-ch.stops:
+		return
+	default:
+	}
+	ch.persist(items)
+}
+
+// throttle returns how long the ingestion endpoint asked the channel to wait
+// before sending more items.
+func (ch *batchChannel) throttle() time.Duration {
+	return time.Until(time.Unix(0, ch.throttledUntil.Load()))
+}
+
+// wait waits for the duration and reports whether it elapsed. It gives up
+// when the channel is stopped, or when the channel is closing and the wait
+// would end after the deadline of closing it.
+func (ch *batchChannel) wait(d time.Duration) bool {
+	end := time.Now().Add(d)
+	closing := ch.closing
+	for {
+		select {
+		case <-ch.closing:
+			deadline := ch.closeDeadline
+			if !deadline.IsZero() && end.After(deadline) {
+				return false
+			}
+			closing = nil
+		default:
+		}
+
+		timer := time.NewTimer(time.Until(end))
+		select {
+		case <-timer.C:
+			return true
+		case <-ch.stops:
+			timer.Stop()
+			return false
+		case <-closing:
+			timer.Stop()
+		}
+	}
+}
+
+// persist saves the items in the offline storage, or discards them if the
+// channel has no offline storage.
+func (ch *batchChannel) persist(items [][]byte) {
+	if ch.store == nil {
+		ch.log(fmt.Sprintf(
+			"Gave up transmitting %d telemetry items; exhausted retries",
+			len(items),
+		))
+		ch.counters.batchesDropped.Add(1)
+		return
+	}
+
+	payload, err := compress(items)
+	if err == nil {
+		err = ch.store.save(payload)
+	}
+	if err != nil {
+		ch.log("Failed to persist telemetry: " + err.Error())
+		ch.counters.batchesDropped.Add(1)
+		return
+	}
+	ch.log("Telemetry persisted to offline storage")
+	ch.counters.batchesPersisted.Add(1)
+}
+
+// observe counts the items of the transmitted batch that were sent and that
+// were rejected permanently, and logs the reasons of the rejections. Items
+// to be retried are counted when they are sent again.
+func (ch *batchChannel) observe(
+	tr *transmission,
+	items [][]byte,
+	retry [][]byte,
+) {
+	rejected, reasons := tr.rejectedItems(len(items))
+	if sent := len(items) - rejected - len(retry); sent > 0 {
+		ch.counters.itemsSent.Add(uint64(sent))
+	}
+	if rejected == 0 {
+		return
+	}
+	ch.counters.itemsRejected.Add(uint64(rejected))
+
+	msg := fmt.Sprintf(
+		"Ingestion rejected %d of %d telemetry items with status %d",
+		rejected, len(items), tr.statusCode,
+	)
+	if len(reasons) > maxRejectionReasons {
+		more := len(reasons) - maxRejectionReasons
+		reasons = append(
+			reasons[:maxRejectionReasons],
+			fmt.Sprintf("and %d more", more),
+		)
+	}
+	if len(reasons) > 0 {
+		msg += ": " + strings.Join(reasons, "; ")
+	}
+	ch.log(msg)
+}
+
+// replay sends the items persisted in the offline storage from oldest to
+// newest, until all of them are sent, a transient error occurs, or the
+// channel is throttled, closed or stopped. Items that are rejected
+// transiently are persisted again. Only one replay runs at a time. The
+// caller adds the replay to the channel's wait group.
+func (ch *batchChannel) replay() {
+	defer ch.wg.Done()
+	if !ch.replaying.CompareAndSwap(false, true) {
+		return
+	}
+	defer ch.replaying.Store(false)
+
+	files, err := ch.store.list()
+	if err != nil {
+		ch.log("Failed to list offline storage: " + err.Error())
+		return
+	}
+
+	for _, f := range files {
+		select {
+		case <-ch.closing:
+			return
+		case <-ch.stops:
+			return
+		default:
+		}
+		if ch.throttle() > 0 {
+			return
+		}
+
+		payload, err := ch.store.read(f.name)
+		if err != nil {
+			continue
+		}
+
+		tr, err := ch.transmitter.transmit(context.Background(), payload)
+		if err != nil || tr.failed() {
+			return
+		}
+
+		ch.store.remove(f.name)
+		items, err := decompress(payload)
+		if err != nil {
+			continue
+		}
+		retry := tr.retryItems(items)
+		ch.observe(tr, items, retry)
+		if len(retry) > 0 {
+			ch.persist(retry)
+		}
+	}
+}
+
+// log writes a message to the logger if there is one.
+func (ch *batchChannel) log(msg string) {
+	if ch.logger != nil {
+		ch.logger(msg)
+	}
+}
@@ -0,0 +1,366 @@
+package apex
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestEnvelope creates an envelope with the name to be sent on channels.
+func newTestEnvelope(name string) *contracts.Envelope {
+	env := contracts.NewEnvelope()
+	env.Name = name
+	return env
+}
+
+// TestChannelBatching tests that items are sent in batches when the batch is
+// full, when the interval elapses and when the channel is flushed or closed
+func TestChannelBatching(t *testing.T) {
+	srv := newMockIngestion(nil)
+	defer srv.Close()
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, nil, 2, 50*time.Millisecond)
+
+	ch.Send(newTestEnvelope("a"))
+	ch.Send(newTestEnvelope("b"))
+	assert.Eventually(t, func() bool {
+		return len(srv.received()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 2, len(srv.received()[0]))
+
+	ch.Send(newTestEnvelope("c"))
+	assert.Eventually(t, func() bool {
+		return len(srv.received()) == 2
+	}, time.Second, time.Millisecond)
+
+	ch.Send(newTestEnvelope("d"))
+	ch.Flush()
+	assert.Eventually(t, func() bool {
+		return len(srv.received()) == 3
+	}, time.Second, time.Millisecond)
+
+	ch.Send(newTestEnvelope("e"))
+	<-ch.Close()
+	assert.Equal(t, 4, len(srv.received()))
+	assert.Equal(t, "e", srv.received()[3][0]["name"])
+
+	ch.Send(newTestEnvelope("f"))
+	ch.Flush()
+	assert.Equal(t, 4, len(srv.received()))
+}
+
+// TestChannelPartialRetry tests that only the items rejected transiently in
+// a partial success are retried
+func TestChannelPartialRetry(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			if n == 1 {
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(`{"itemsReceived":3,"itemsAccepted":1,"errors":[` +
+					`{"index":0,"statusCode":400,"message":"invalid"},` +
+					`{"index":2,"statusCode":503,"message":"unavailable"}]}`))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+	defer srv.Close()
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, nil, 3, time.Minute)
+	ch.backoff = []time.Duration{time.Millisecond}
+
+	ch.Send(newTestEnvelope("a"))
+	ch.Send(newTestEnvelope("b"))
+	ch.Send(newTestEnvelope("c"))
+	<-ch.Close(time.Minute)
+
+	batches := srv.received()
+	assert.Equal(t, 2, len(batches))
+	assert.Equal(t, 3, len(batches[0]))
+	assert.Equal(t, 1, len(batches[1]))
+	assert.Equal(t, "c", batches[1][0]["name"])
+}
+
+// TestChannelRejected tests that items rejected permanently are logged and
+// counted, along with the items that were sent
+func TestChannelRejected(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			switch n {
+			case 1:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"itemsReceived":2,"itemsAccepted":0,"errors":[` +
+					`{"index":0,"statusCode":400,"message":"invalid schema"}]}`))
+			case 2:
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(`{"itemsReceived":2,"itemsAccepted":1,"errors":[` +
+					`{"index":1,"statusCode":400,"message":"invalid"}]}`))
+			default:
+				w.WriteHeader(http.StatusOK)
+			}
+		},
+	)
+	defer srv.Close()
+
+	logs := make(chan string, 10)
+	logger := func(msg string) error {
+		logs <- msg
+		return nil
+	}
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, logger, 2, time.Minute)
+
+	ch.Send(newTestEnvelope("a"))
+	ch.Send(newTestEnvelope("b"))
+	assert.Equal(t,
+		"Ingestion rejected 2 of 2 telemetry items with status 400: "+
+			"item 0: 400 invalid schema",
+		<-logs,
+	)
+
+	ch.Send(newTestEnvelope("c"))
+	ch.Send(newTestEnvelope("d"))
+	assert.Equal(t,
+		"Ingestion rejected 1 of 2 telemetry items with status 206: "+
+			"item 1: 400 invalid",
+		<-logs,
+	)
+
+	ch.Send(newTestEnvelope("e"))
+	<-ch.Close(time.Minute)
+
+	assert.Equal(t, 3, len(srv.received()))
+	assert.Equal(t, uint64(2), ch.counters.itemsSent.Load())
+	assert.Equal(t, uint64(3), ch.counters.itemsRejected.Load())
+	assert.Equal(t, uint64(0), ch.counters.batchesDropped.Load())
+}
+
+// TestChannelDropped tests that batches are counted as dropped when their
+// retries are exhausted without an offline storage
+func TestChannelDropped(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	)
+	defer srv.Close()
+
+	logs := make(chan string, 10)
+	logger := func(msg string) error {
+		logs <- msg
+		return nil
+	}
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, logger, 10, time.Minute)
+	ch.backoff = []time.Duration{time.Millisecond}
+
+	ch.Send(newTestEnvelope("a"))
+	<-ch.Close(time.Minute)
+
+	assert.Equal(t, 2, len(srv.received()))
+	assert.Equal(t, "Gave up transmitting 1 telemetry items; exhausted retries", <-logs)
+	assert.Equal(t, uint64(0), ch.counters.itemsSent.Load())
+	assert.Equal(t, uint64(1), ch.counters.batchesDropped.Load())
+}
+
+// TestChannelRetryAfter tests that the channel waits as long as requested by
+// the ingestion endpoint before retrying
+func TestChannelRetryAfter(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			if n == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+	defer srv.Close()
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, nil, 1, time.Minute)
+	ch.backoff = []time.Duration{time.Millisecond}
+
+	start := time.Now()
+	ch.Send(newTestEnvelope("a"))
+	assert.Eventually(t, func() bool {
+		return ch.IsThrottled()
+	}, time.Second, time.Millisecond)
+	<-ch.Close(time.Minute)
+
+	assert.Equal(t, 2, len(srv.received()))
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	assert.False(t, ch.IsThrottled())
+}
+
+// TestChannelThrottled tests that new batches are held while the ingestion
+// endpoint throttles the channel
+func TestChannelThrottled(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			if n == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+	defer srv.Close()
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, nil, 1, time.Minute)
+
+	start := time.Now()
+	ch.Send(newTestEnvelope("a"))
+	assert.Eventually(t, func() bool {
+		return ch.IsThrottled()
+	}, time.Second, time.Millisecond)
+
+	ch.Send(newTestEnvelope("b"))
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 1, len(srv.received()))
+
+	<-ch.Close(time.Minute)
+	assert.Equal(t, 3, len(srv.received()))
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	assert.Equal(t, uint64(2), ch.counters.itemsSent.Load())
+}
+
+// TestChannelSendClosed tests that items sent after the channel is closed
+// are discarded and logged
+func TestChannelSendClosed(t *testing.T) {
+	srv := newMockIngestion(nil)
+	defer srv.Close()
+
+	logs := make(chan string, 10)
+	logger := func(msg string) error {
+		logs <- msg
+		return nil
+	}
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, logger, 10, time.Minute)
+	<-ch.Close()
+
+	for i := 0; i < 5; i++ {
+		ch.Send(newTestEnvelope("a"))
+		assert.Equal(t, "Telemetry item discarded; channel is closed", <-logs)
+	}
+	assert.Equal(t, 0, len(ch.items))
+	assert.Equal(t, 0, len(srv.received()))
+}
+
+// TestChannelCloseDeadline tests that the retries of batches sent before the
+// channel is closed are limited by the retry timeout of closing it
+func TestChannelCloseDeadline(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	)
+	defer srv.Close()
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, nil, 1, time.Minute)
+	ch.backoff = []time.Duration{time.Minute}
+
+	ch.Send(newTestEnvelope("a"))
+	assert.Eventually(t, func() bool {
+		return len(srv.received()) == 1
+	}, time.Second, time.Millisecond)
+
+	select {
+	case <-ch.Close(50 * time.Millisecond):
+	case <-time.After(time.Second):
+		t.Fatal("channel did not close")
+	}
+	assert.Equal(t, 1, len(srv.received()))
+	assert.Equal(t, uint64(1), ch.counters.batchesDropped.Load())
+}
+
+// TestChannelCloseReplay tests that closing the channel waits for the replay
+// of the offline storage in progress
+func TestChannelCloseReplay(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+	defer srv.Close()
+
+	store, err := newDiskStore(&storage{dir: t.TempDir()})
+	assert.Nil(t, err)
+	payload, _ := compress([][]byte{[]byte(`{"name":"a"}`)})
+	assert.Nil(t, store.save(payload))
+	assert.Nil(t, store.save(payload))
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, store, nil, 10, time.Minute)
+	assert.Eventually(t, func() bool {
+		return len(srv.received()) == 1
+	}, time.Second, time.Millisecond)
+	<-ch.Close(time.Minute)
+
+	files, _ := store.list()
+	assert.Equal(t, 1, len(srv.received()))
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, uint64(1), ch.counters.itemsSent.Load())
+}
+
+// TestChannelCloseWithoutRetry tests that closing the channel without a retry
+// timeout does not retry failed items
+func TestChannelCloseWithoutRetry(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	)
+	defer srv.Close()
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, nil, 10, time.Minute)
+
+	ch.Send(newTestEnvelope("a"))
+	select {
+	case <-ch.Close():
+	case <-time.After(time.Second):
+		t.Fatal("channel did not close")
+	}
+	assert.Equal(t, 1, len(srv.received()))
+}
+
+// TestChannelStop tests that stopping the channel abandons retries
+func TestChannelStop(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	)
+	defer srv.Close()
+
+	tr := &transmitter{endpoint: srv.URL, client: http.DefaultClient}
+	ch := newBatchChannel(tr, nil, nil, 1, time.Minute)
+
+	ch.Send(newTestEnvelope("a"))
+	assert.Eventually(t, func() bool {
+		return len(srv.received()) == 1
+	}, time.Second, time.Millisecond)
+
+	ch.Stop()
+	select {
+	case <-ch.Close(time.Minute):
+	case <-time.After(time.Second):
+		t.Fatal("channel did not close")
+	}
+	assert.Equal(t, 1, len(srv.received()))
+}
@@ -0,0 +1,215 @@
+package apex
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// sdkVersion identifies apex as the sdk that produced the telemetry.
+const sdkVersion = "go-apex"
+
+// telemetryClient is an app insights telemetry client that wraps telemetry
+// items in envelopes and submits them to a telemetry channel.
+type telemetryClient struct {
+	channel  appinsights.TelemetryChannel
+	context  *appinsights.TelemetryContext
+	nameIKey string
+	disabled atomic.Bool
+	logger   func(msg string) error
+
+	// sampleRate is the percentage of telemetry sampled in, recorded on the
+	// envelopes so that the ingestion service can account for the rest.
+	sampleRate float64
+}
+
+// newTelemetryClient creates a telemetry client that submits telemetry with
+// the instrumentation key to the channel.
+func newTelemetryClient(
+	instrumentationKey string,
+	channel appinsights.TelemetryChannel,
+	logger func(msg string) error,
+) *telemetryClient {
+	ctx := appinsights.NewTelemetryContext(instrumentationKey)
+	ctx.Tags.Internal().SetSdkVersion(sdkVersion)
+	ctx.Tags.Device().SetOsVersion(runtime.GOOS)
+	if hostname, err := os.Hostname(); err == nil {
+		ctx.Tags.Device().SetId(hostname)
+		ctx.Tags.Cloud().SetRoleInstance(hostname)
+	}
+
+	return &telemetryClient{
+		channel:    channel,
+		context:    ctx,
+		nameIKey:   strings.ReplaceAll(instrumentationKey, "-", ""),
+		logger:     logger,
+		sampleRate: 100,
+	}
+}
+
+// Context returns the telemetry context whose tags and common properties
+// are added to every telemetry item.
+func (tc *telemetryClient) Context() *appinsights.TelemetryContext {
+	return tc.context
+}
+
+// InstrumentationKey returns the instrumentation key of the client.
+func (tc *telemetryClient) InstrumentationKey() string {
+	return tc.context.InstrumentationKey()
+}
+
+// Channel returns the telemetry channel the client submits telemetry to.
+func (tc *telemetryClient) Channel() appinsights.TelemetryChannel {
+	return tc.channel
+}
+
+// IsEnabled checks if the client accepts telemetry.
+func (tc *telemetryClient) IsEnabled() bool {
+	return !tc.disabled.Load()
+}
+
+// SetIsEnabled enables or disables the client. Telemetry submitted to a
+// disabled client is discarded.
+func (tc *telemetryClient) SetIsEnabled(enabled bool) {
+	tc.disabled.Store(!enabled)
+}
+
+// Track wraps the telemetry item in an envelope and submits it to the
+// channel.
+func (tc *telemetryClient) Track(item appinsights.Telemetry) {
+	if tc.IsEnabled() && item != nil {
+		tc.channel.Send(tc.envelop(item))
+	}
+}
+
+// TrackEvent submits an event telemetry with the name.
+func (tc *telemetryClient) TrackEvent(name string) {
+	tc.Track(appinsights.NewEventTelemetry(name))
+}
+
+// TrackMetric submits a metric telemetry with the name and value.
+func (tc *telemetryClient) TrackMetric(name string, value float64) {
+	tc.Track(appinsights.NewMetricTelemetry(name, value))
+}
+
+// TrackTrace submits a trace telemetry with the message and severity.
+func (tc *telemetryClient) TrackTrace(
+	message string,
+	severity contracts.SeverityLevel,
+) {
+	tc.Track(appinsights.NewTraceTelemetry(message, severity))
+}
+
+// TrackRequest submits a request telemetry.
+func (tc *telemetryClient) TrackRequest(
+	method, url string,
+	duration time.Duration,
+	responseCode string,
+) {
+	tc.Track(appinsights.NewRequestTelemetry(method, url, duration, responseCode))
+}
+
+// TrackRemoteDependency submits a dependency telemetry.
+func (tc *telemetryClient) TrackRemoteDependency(
+	name, dependencyType, target string,
+	success bool,
+) {
+	tc.Track(appinsights.NewRemoteDependencyTelemetry(
+		name, dependencyType, target, success,
+	))
+}
+
+// TrackAvailability submits an availability telemetry.
+func (tc *telemetryClient) TrackAvailability(
+	name string,
+	duration time.Duration,
+	success bool,
+) {
+	tc.Track(appinsights.NewAvailabilityTelemetry(name, duration, success))
+}
+
+// TrackException submits an exception telemetry for the error, which may be
+// a string, error or Stringer.
+func (tc *telemetryClient) TrackException(err interface{}) {
+	tc.Track(appinsights.NewExceptionTelemetry(err))
+}
+
+// envelop wraps the telemetry item in an envelope, completing it with the
+// common properties and tags of the client's context.
+func (tc *telemetryClient) envelop(item appinsights.Telemetry) *contracts.Envelope {
+	if props := item.GetProperties(); props != nil {
+		for k, v := range tc.context.CommonProperties {
+			if _, ok := props[k]; !ok {
+				props[k] = v
+			}
+		}
+	}
+
+	tdata := item.TelemetryData()
+	data := contracts.NewData()
+	data.BaseType = tdata.BaseType()
+	data.BaseData = tdata
+
+	env := contracts.NewEnvelope()
+	env.Name = tdata.EnvelopeName(tc.nameIKey)
+	env.Data = data
+	env.IKey = tc.context.InstrumentationKey()
+	env.SampleRate = tc.sampleRate
+
+	timestamp := item.Time()
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	env.Time = timestamp.UTC().Format("2006-01-02T15:04:05.999999Z")
+
+	env.Tags = map[string]string{}
+	for k, v := range item.ContextTags() {
+		env.Tags[k] = v
+	}
+	for k, v := range tc.context.Tags {
+		if _, ok := env.Tags[k]; !ok {
+			env.Tags[k] = v
+		}
+	}
+	if _, ok := env.Tags[contracts.OperationId]; !ok {
+		env.Tags[contracts.OperationId] = newOperationId()
+	}
+
+	for _, warn := range tdata.Sanitize() {
+		tc.log("Telemetry data warning: " + warn)
+	}
+	for _, warn := range contracts.SanitizeTags(env.Tags) {
+		tc.log("Telemetry tag warning: " + warn)
+	}
+	return env
+}
+
+// log writes a message to the logger if there is one.
+func (tc *telemetryClient) log(msg string) {
+	if tc.logger != nil {
+		tc.logger(msg)
+	}
+}
+
+// newSpanId generates a random id with the size of a span id for telemetry
+// that is not created from a span.
+func newSpanId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newOperationId generates a random operation id for telemetry that is not
+// correlated to a trace.
+func newOperationId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,67 @@
+package apex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockChannel struct {
+	appinsights.TelemetryChannel
+	envs []*contracts.Envelope
+}
+
+func (ch *mockChannel) Send(env *contracts.Envelope) {
+	ch.envs = append(ch.envs, env)
+}
+
+// TestClientTrack tests that telemetry is wrapped in envelopes completed
+// from the client's context
+func TestClientTrack(t *testing.T) {
+	ch := &mockChannel{}
+	tc := newTelemetryClient("00000000-0000-0000-0000-000000000001", ch, nil)
+	tc.Context().CommonProperties["env"] = "test"
+	tc.Context().Tags.Cloud().SetRole("default-role")
+
+	now := time.Now()
+	tele := appinsights.NewEventTelemetry("event")
+	tele.Timestamp = now
+	tele.Properties["env"] = "override"
+	tele.Tags.Cloud().SetRole("role")
+	tele.Tags.Operation().SetId("operation")
+	tc.Track(tele)
+
+	tc.TrackTrace("message", contracts.Warning)
+
+	tc.SetIsEnabled(false)
+	tc.TrackEvent("disabled")
+	assert.False(t, tc.IsEnabled())
+
+	assert.Equal(t, "00000000-0000-0000-0000-000000000001", tc.InstrumentationKey())
+	assert.Equal(t, ch, tc.Channel())
+	assert.Equal(t, 2, len(ch.envs))
+
+	env := ch.envs[0]
+	assert.Equal(t, "Microsoft.ApplicationInsights.00000000000000000000000000000001.Event", env.Name)
+	assert.Equal(t, "00000000-0000-0000-0000-000000000001", env.IKey)
+	assert.Equal(t, now.UTC().Format("2006-01-02T15:04:05.999999Z"), env.Time)
+	assert.Equal(t, "role", env.Tags["ai.cloud.role"])
+	assert.Equal(t, "operation", env.Tags["ai.operation.id"])
+	assert.Equal(t, sdkVersion, env.Tags["ai.internal.sdkVersion"])
+
+	data := env.Data.(*contracts.Data)
+	assert.Equal(t, "EventData", data.BaseType)
+	event := data.BaseData.(*contracts.EventData)
+	assert.Equal(t, "event", event.Name)
+	assert.Equal(t, "override", event.Properties["env"])
+
+	env = ch.envs[1]
+	assert.Equal(t, "default-role", env.Tags["ai.cloud.role"])
+	assert.Equal(t, 32, len(env.Tags["ai.operation.id"]))
+	assert.NotEmpty(t, env.Time)
+	trace := env.Data.(*contracts.Data).BaseData.(*contracts.MessageData)
+	assert.Equal(t, "test", trace.Properties["env"])
+}
@@ -0,0 +1,107 @@
+package apex
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+)
+
+// Default endpoints of the ingestion and live metrics services.
+const (
+	defaultIngestionEndpoint = "https://dc.services.visualstudio.com"
+	defaultLiveEndpoint      = "https://rt.services.visualstudio.com"
+)
+
+// connectionString holds the settings of an Application Insights connection
+// string.
+type connectionString struct {
+	instrumentationKey string
+	ingestionEndpoint  string
+	liveEndpoint       string
+}
+
+// trackEndpoint returns the url telemetry is submitted to.
+func (cs *connectionString) trackEndpoint() string {
+	return cs.ingestionEndpoint + "/v2.1/track"
+}
+
+// parseConnectionString parses an Application Insights connection string,
+// made of semicolon separated key=value pairs. The endpoints are derived
+// from the endpoint suffix or default to the public cloud, unless they are
+// set explicitly.
+func parseConnectionString(s string) (*connectionString, error) {
+	vals := map[string]string{}
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, errors.New("connection string is malformed")
+		}
+		vals[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(val)
+	}
+
+	cs := &connectionString{
+		instrumentationKey: vals["instrumentationkey"],
+		ingestionEndpoint:  defaultIngestionEndpoint,
+		liveEndpoint:       defaultLiveEndpoint,
+	}
+	if cs.instrumentationKey == "" {
+		return nil, errors.New("connection string has no instrumentation key")
+	}
+
+	if suffix := strings.Trim(vals["endpointsuffix"], "./"); suffix != "" {
+		cs.ingestionEndpoint = "https://dc." + suffix
+		cs.liveEndpoint = "https://live." + suffix
+	}
+	if val, ok := vals["ingestionendpoint"]; ok {
+		cs.ingestionEndpoint = val
+	}
+	if val, ok := vals["liveendpoint"]; ok {
+		cs.liveEndpoint = val
+	}
+
+	for _, ep := range []string{cs.ingestionEndpoint, cs.liveEndpoint} {
+		if err := validateURL(ep); err != nil {
+			return nil, err
+		}
+	}
+	cs.ingestionEndpoint = strings.TrimRight(cs.ingestionEndpoint, "/")
+	cs.liveEndpoint = strings.TrimRight(cs.liveEndpoint, "/")
+	return cs, nil
+}
+
+// validateURL checks that the value is an absolute http or https url.
+func validateURL(val string) error {
+	u, err := url.Parse(val)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url " + val + " is not an absolute http url")
+	}
+	return nil
+}
+
+// NewExporterFromConnectionString creates a new App Insights Exporter with an
+// app insights telemetry client created from a connection string. The
+// exporter uses a logger function provided as a callback for logging events.
+func NewExporterFromConnectionString(
+	connectionString string,
+	logger func(msg string) error,
+	opts ...Option,
+) (*AppInsightsExporter, error) {
+	cs, err := parseConnectionString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := appinsights.NewTelemetryConfiguration(cs.instrumentationKey)
+	cfg.EndpointUrl = cs.trackEndpoint()
+	opts = append([]Option{withLiveEndpoint(cs.liveEndpoint)}, opts...)
+	return NewExporterFromConfig(cfg, logger, opts...)
+}
@@ -0,0 +1,82 @@
+package apex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseConnectionString tests that connection strings are parsed into
+// instrumentation keys and endpoints accurately
+func TestParseConnectionString(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Value     string
+		Error     bool
+		Ikey      string
+		Ingestion string
+		Live      string
+	}{
+		{
+			Name:      "Instrumentation key only",
+			Value:     "InstrumentationKey=00000000-0000-0000-0000-000000000000",
+			Ikey:      "00000000-0000-0000-0000-000000000000",
+			Ingestion: defaultIngestionEndpoint,
+			Live:      defaultLiveEndpoint,
+		},
+		{
+			Name: "Explicit endpoints",
+			Value: "InstrumentationKey=key;" +
+				"IngestionEndpoint=https://westeurope-5.in.applicationinsights.azure.com/;" +
+				"LiveEndpoint=https://westeurope.livediagnostics.monitor.azure.com/",
+			Ikey:      "key",
+			Ingestion: "https://westeurope-5.in.applicationinsights.azure.com",
+			Live:      "https://westeurope.livediagnostics.monitor.azure.com",
+		},
+		{
+			Name:      "Endpoint suffix",
+			Value:     "instrumentationkey=key; endpointsuffix=applicationinsights.azure.cn",
+			Ikey:      "key",
+			Ingestion: "https://dc.applicationinsights.azure.cn",
+			Live:      "https://live.applicationinsights.azure.cn",
+		},
+		{
+			Name: "Endpoint suffix with override",
+			Value: "InstrumentationKey=key;EndpointSuffix=applicationinsights.azure.cn;" +
+				"IngestionEndpoint=https://custom.example.com;",
+			Ikey:      "key",
+			Ingestion: "https://custom.example.com",
+			Live:      "https://live.applicationinsights.azure.cn",
+		},
+		{
+			Name:  "Missing instrumentation key",
+			Value: "IngestionEndpoint=https://custom.example.com",
+			Error: true,
+		},
+		{
+			Name:  "Malformed pair",
+			Value: "InstrumentationKey=key;IngestionEndpoint",
+			Error: true,
+		},
+		{
+			Name:  "Invalid endpoint",
+			Value: "InstrumentationKey=key;IngestionEndpoint=custom.example.com",
+			Error: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			cs, err := parseConnectionString(test.Value)
+			if test.Error {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.Ikey, cs.instrumentationKey)
+			assert.Equal(t, test.Ingestion, cs.ingestionEndpoint)
+			assert.Equal(t, test.Live, cs.liveEndpoint)
+			assert.Equal(t, test.Ingestion+"/v2.1/track", cs.trackEndpoint())
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package apex
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// requestContextAttribute is the attribute of the Request-Context response
+// header captured by http client instrumentations.
+const requestContextAttribute = "http.response.header.request-context"
+
+// maxAppIds limits the number of hosts whose application ids are cached.
+const maxAppIds = 1024
+
+// appIdCache remembers the application ids of the Application Insights
+// resources that dependencies called, by host.
+type appIdCache struct {
+	mtx sync.RWMutex
+	ids map[string]string
+}
+
+// newAppIdCache creates an empty application id cache.
+func newAppIdCache() *appIdCache {
+	return &appIdCache{ids: map[string]string{}}
+}
+
+// resolve returns the application id of the host, taken from the captured
+// Request-Context header if there is one, or from earlier responses of the
+// host otherwise.
+func (c *appIdCache) resolve(host, header string) string {
+	appId := parseRequestContext(strings.NewReplacer(
+		"[", "", "]", "", `"`, "",
+	).Replace(header))
+
+	if host == "" {
+		return appId
+	}
+	if appId == "" {
+		c.mtx.RLock()
+		defer c.mtx.RUnlock()
+		return c.ids[host]
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.ids[host]; ok || len(c.ids) < maxAppIds {
+		c.ids[host] = appId
+	}
+	return appId
+}
+
+// requestContext returns the captured Request-Context response header of the
+// span, which instrumentations record as a string or a list of strings.
+func requestContext(sp sdktrace.ReadOnlySpan) string {
+	return spanAttribute(sp, requestContextAttribute)
+}
+
+// dependencyHost finds the host a dependency called from the span's semantic
+// convention attributes.
+func dependencyHost(sp sdktrace.ReadOnlySpan) string {
+	if name := spanAttribute(sp, semconv.NetPeerNameKey); name != "" {
+		if port := spanAttribute(sp, semconv.NetPeerPortKey); port != "" {
+			return name + ":" + port
+		}
+		return name
+	}
+	if val := spanAttribute(sp, semconv.HTTPURLKey); val != "" {
+		if u, err := url.Parse(val); err == nil {
+			return u.Host
+		}
+	}
+	return ""
+}
+
+// dependencyTarget formats the target of a dependency, adding the
+// application id of the called resource like Application Insights SDKs do.
+func dependencyTarget(host, appId string) string {
+	if appId == "" {
+		return host
+	}
+	return host + " | " + appIdPrefix + appId
+}
@@ -0,0 +1,115 @@
+package apex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestDependencyHost tests that the called host is found from semantic
+// convention attributes
+func TestDependencyHost(t *testing.T) {
+	tests := []struct {
+		Name string
+		Attr []attribute.KeyValue
+		Host string
+	}{
+		{
+			Name: "Peer name",
+			Attr: []attribute.KeyValue{attribute.String("net.peer.name", "orders")},
+			Host: "orders",
+		},
+		{
+			Name: "Peer name and port",
+			Attr: []attribute.KeyValue{
+				attribute.String("net.peer.name", "orders"),
+				attribute.Int("net.peer.port", 8080),
+			},
+			Host: "orders:8080",
+		},
+		{
+			Name: "Url",
+			Attr: []attribute.KeyValue{
+				attribute.String("http.url", "https://orders.example.com:8443/v1/orders?id=1"),
+			},
+			Host: "orders.example.com:8443",
+		},
+		{
+			Name: "No attributes",
+			Host: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Host, dependencyHost(&mockSpan{attr: test.Attr}))
+		})
+	}
+}
+
+// TestAppIdCache tests that application ids are resolved from Request-Context
+// headers and cached by host
+func TestAppIdCache(t *testing.T) {
+	c := newAppIdCache()
+
+	assert.Equal(t, "", c.resolve("orders", ""))
+	assert.Equal(t, "app1", c.resolve("orders", `["appId=cid-v1:app1"]`))
+	assert.Equal(t, "app1", c.resolve("orders", ""))
+	assert.Equal(t, "app2", c.resolve("orders", "appId=cid-v1:app2, roleName=orders"))
+	assert.Equal(t, "app2", c.resolve("orders", ""))
+	assert.Equal(t, "", c.resolve("payments", ""))
+	assert.Equal(t, "app3", c.resolve("", "appId=cid-v1:app3"))
+	assert.Equal(t, 1, len(c.ids))
+}
+
+// TestProcessDependencyTarget tests that dependency targets are linked to the
+// application ids of the called resources
+func TestProcessDependencyTarget(t *testing.T) {
+	tcl := &mockTelemetryClient{}
+	exp, _ := NewExporter("", nil)
+	exp.client = tcl
+
+	res, _ := resource.New(context.Background())
+	newSpan := func(attr ...attribute.KeyValue) *mockSpan {
+		return &mockSpan{
+			name:   "GET /orders",
+			kind:   trace.SpanKindClient,
+			status: sdktrace.Status{Code: codes.Ok},
+			res:    res,
+			attr:   attr,
+		}
+	}
+
+	exp.process(newSpan(
+		attribute.String("http.url", "https://orders.example.com/orders"),
+	))
+	exp.process(newSpan(
+		attribute.String("http.url", "https://orders.example.com/orders"),
+		attribute.StringSlice(requestContextAttribute, []string{"appId=cid-v1:orders-app"}),
+	))
+	exp.process(newSpan(
+		attribute.String("http.url", "https://orders.example.com/orders/1"),
+	))
+	exp.process(newSpan(
+		attribute.String("net.peer.name", "payments"),
+	))
+
+	targets := []string{}
+	for _, tel := range tcl.tels {
+		targets = append(targets, tel.(*appinsights.RemoteDependencyTelemetry).Target)
+	}
+	assert.Equal(t, []string{
+		"orders.example.com",
+		"orders.example.com | cid-v1:orders-app",
+		"orders.example.com | cid-v1:orders-app",
+		"payments",
+	}, targets)
+	assert.NotContains(t, tcl.tels[1].GetProperties(), requestContextAttribute)
+}
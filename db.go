@@ -0,0 +1,227 @@
+package apex
+
+import (
+	"encoding/json"
+	"strings"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// dbTypes maps database systems of the semantic conventions to the
+// dependency types that Application Insights displays for them.
+var dbTypes = map[string]string{
+	"mssql":         "SQL",
+	"mysql":         "SQL",
+	"mariadb":       "SQL",
+	"postgresql":    "SQL",
+	"oracle":        "SQL",
+	"db2":           "SQL",
+	"sqlite":        "SQL",
+	"cockroachdb":   "SQL",
+	"other_sql":     "SQL",
+	"redis":         "Redis",
+	"mongodb":       "MongoDB",
+	"cosmosdb":      "Azure DocumentDB",
+	"cassandra":     "Cassandra",
+	"memcached":     "Memcached",
+	"elasticsearch": "Elasticsearch",
+}
+
+// dbType selects the dependency type of a database system, or returns the
+// system itself if it is not well known.
+func dbType(system string) string {
+	if t, ok := dbTypes[system]; ok {
+		return t
+	}
+	return system
+}
+
+// dbTarget formats the target of a database dependency from the called host
+// and the name of the database, like Application Insights SDKs do.
+func dbTarget(sp sdktrace.ReadOnlySpan) string {
+	host := dependencyHost(sp)
+	name := spanAttribute(sp, semconv.DBNameKey)
+	switch {
+	case host != "" && name != "":
+		return host + " | " + name
+	case name != "":
+		return name
+	}
+	return host
+}
+
+// sanitizeStatement removes literals from the statement of the database
+// system, so that values in it do not leave the process.
+func sanitizeStatement(system, statement string) string {
+	switch dbType(system) {
+	case "Redis":
+		return sanitizeRedis(statement)
+	case "MongoDB", "Azure DocumentDB", "Elasticsearch":
+		if sanitized, ok := sanitizeJSON(statement); ok {
+			return sanitized
+		}
+	}
+	return sanitizeSQL(system, statement)
+}
+
+// sanitizeSQL replaces string and numeric literals in the statement with
+// placeholders, and removes comments. The literals of the database system's
+// dialect are recognized, such as strings escaped with backslashes and
+// double quoted strings of MySQL, and escape strings like E'it\'s' and dollar
+// quoted strings of PostgreSQL.
+func sanitizeSQL(system, statement string) string {
+	mysql := system == "mysql" || system == "mariadb"
+	postgres := system == "postgresql"
+
+	var sb strings.Builder
+	sb.Grow(len(statement))
+
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == '\'' || (c == '"' && mysql):
+			i = skipQuoted(statement, i, mysql)
+			sb.WriteByte('?')
+		case (c == 'E' || c == 'e') && postgres && i+1 < len(statement) &&
+			statement[i+1] == '\'' && (i == 0 || !isIdentifier(statement[i-1])):
+			i = skipQuoted(statement, i+1, true)
+			sb.WriteByte('?')
+		case c == '$' && postgres && (i == 0 || !isIdentifier(statement[i-1])):
+			end, ok := skipDollarQuoted(statement, i)
+			if !ok {
+				sb.WriteByte(c)
+				i++
+				continue
+			}
+			i = end
+			sb.WriteByte('?')
+		case c == '-' && i+1 < len(statement) && statement[i+1] == '-':
+			for i < len(statement) && statement[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(statement) && statement[i+1] == '*':
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				i = len(statement)
+			} else {
+				i += end + 4
+			}
+		case isDigit(c) && (i == 0 || !isIdentifier(statement[i-1])):
+			for i < len(statement) &&
+				(isIdentifier(statement[i]) || statement[i] == '.') {
+				i++
+			}
+			sb.WriteByte('?')
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// skipQuoted returns the position after the quoted literal that starts at
+// the position, where quotes are escaped by doubling them, or also with a
+// backslash if backslash escapes are enabled. Unterminated literals extend
+// to the end of the statement.
+func skipQuoted(statement string, start int, backslash bool) int {
+	quote := statement[start]
+	for i := start + 1; i < len(statement); {
+		switch {
+		case backslash && statement[i] == '\\':
+			i += 2
+		case statement[i] == quote:
+			if i+1 < len(statement) && statement[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(statement)
+}
+
+// skipDollarQuoted returns the position after the dollar quoted literal,
+// like $tag$text$tag$, that starts at the position. Positional parameters
+// like $1 are not literals. Unterminated literals extend to the end of the
+// statement.
+func skipDollarQuoted(statement string, start int) (int, bool) {
+	i := start + 1
+	if i < len(statement) && isDigit(statement[i]) {
+		return 0, false
+	}
+	for i < len(statement) && statement[i] != '$' && isIdentifier(statement[i]) {
+		i++
+	}
+	if i >= len(statement) || statement[i] != '$' {
+		return 0, false
+	}
+
+	tag := statement[start : i+1]
+	end := strings.Index(statement[i+1:], tag)
+	if end < 0 {
+		return len(statement), true
+	}
+	return i + 1 + end + len(tag), true
+}
+
+// sanitizeRedis keeps the command and key of a redis statement and replaces
+// the remaining arguments with placeholders. All arguments of commands with
+// credentials are replaced.
+func sanitizeRedis(statement string) string {
+	fields := strings.Fields(statement)
+	first := 2
+	if len(fields) > 0 && (strings.EqualFold(fields[0], "AUTH") ||
+		strings.EqualFold(fields[0], "HELLO")) {
+		first = 1
+	}
+	for i := first; i < len(fields); i++ {
+		fields[i] = "?"
+	}
+	return strings.Join(fields, " ")
+}
+
+// sanitizeJSON replaces the values in a JSON statement with placeholders,
+// keeping its structure and keys.
+func sanitizeJSON(statement string) (string, bool) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(statement), &doc); err != nil {
+		return "", false
+	}
+	data, err := json.Marshal(redactJSON(doc))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// redactJSON replaces the leaf values of the JSON value with placeholders.
+func redactJSON(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = redactJSON(e)
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactJSON(e)
+		}
+		return v
+	}
+	return "?"
+}
+
+// isDigit checks if the character is a decimal digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isIdentifier checks if the character can be part of an identifier.
+func isIdentifier(c byte) bool {
+	return isDigit(c) || c == '_' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
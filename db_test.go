@@ -0,0 +1,223 @@
+package apex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestSanitizeStatement tests that literals are removed from statements of
+// different database systems
+func TestSanitizeStatement(t *testing.T) {
+	tests := []struct {
+		Name      string
+		System    string
+		Statement string
+		Sanitized string
+	}{
+		{
+			Name:      "SQL string and number literals",
+			System:    "postgresql",
+			Statement: "SELECT * FROM users WHERE name = 'O''Brien' AND age > 42 AND score < 1.5",
+			Sanitized: "SELECT * FROM users WHERE name = ? AND age > ? AND score < ?",
+		},
+		{
+			Name:      "SQL identifiers with digits",
+			System:    "mysql",
+			Statement: "SELECT col1 FROM table2 WHERE id IN (1, 2, 0x1F)",
+			Sanitized: "SELECT col1 FROM table2 WHERE id IN (?, ?, ?)",
+		},
+		{
+			Name:      "SQL parameters",
+			System:    "postgresql",
+			Statement: "UPDATE users SET email = $1 WHERE id = $2",
+			Sanitized: "UPDATE users SET email = $1 WHERE id = $2",
+		},
+		{
+			Name:      "SQL comments",
+			System:    "mssql",
+			Statement: "SELECT 1 -- password=secret\nFROM dual /* token */",
+			Sanitized: "SELECT ? \nFROM dual ",
+		},
+		{
+			Name:      "SQL backslash escapes",
+			System:    "mysql",
+			Statement: `UPDATE notes SET note = 'it\'s my password hunter2' WHERE id = 7`,
+			Sanitized: "UPDATE notes SET note = ? WHERE id = ?",
+		},
+		{
+			Name:      "SQL double quoted strings",
+			System:    "mariadb",
+			Statement: `SELECT * FROM users WHERE password = "secret2" OR password = "a\"b"`,
+			Sanitized: "SELECT * FROM users WHERE password = ? OR password = ?",
+		},
+		{
+			Name:      "SQL dollar quoted strings",
+			System:    "postgresql",
+			Statement: "SELECT $tag$secret$tag$, $$other$$ FROM t WHERE id = $1",
+			Sanitized: "SELECT ?, ? FROM t WHERE id = $1",
+		},
+		{
+			Name:      "SQL unterminated dollar quoted string",
+			System:    "postgresql",
+			Statement: "SELECT $tag$secret",
+			Sanitized: "SELECT ?",
+		},
+		{
+			Name:      "SQL escape strings",
+			System:    "postgresql",
+			Statement: `SELECT * FROM notes WHERE note = E'it\'s secret' OR note = e'a\\' AND type = 'E'`,
+			Sanitized: "SELECT * FROM notes WHERE note = ? OR note = ? AND type = ?",
+		},
+		{
+			Name:      "SQL standard strings and quoted identifiers",
+			System:    "postgresql",
+			Statement: `SELECT "Name" FROM users WHERE path = 'C:\' AND id = 1`,
+			Sanitized: `SELECT "Name" FROM users WHERE path = ? AND id = ?`,
+		},
+		{
+			Name:      "Redis arguments",
+			System:    "redis",
+			Statement: "SET session:42 {\"user\":\"bob\"} EX 3600",
+			Sanitized: "SET session:42 ? ? ?",
+		},
+		{
+			Name:      "Redis credentials",
+			System:    "redis",
+			Statement: "AUTH admin secret",
+			Sanitized: "AUTH ? ?",
+		},
+		{
+			Name:      "MongoDB command",
+			System:    "mongodb",
+			Statement: `{"find":"users","filter":{"age":{"$gt":42},"tags":["a","b"]}}`,
+			Sanitized: `{"filter":{"age":{"$gt":"?"},"tags":["?","?"]},"find":"?"}`,
+		},
+		{
+			Name:      "MongoDB command that is not JSON",
+			System:    "mongodb",
+			Statement: "find users where name = 'bob'",
+			Sanitized: "find users where name = ?",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Sanitized, sanitizeStatement(test.System, test.Statement))
+		})
+	}
+}
+
+// TestProcessDatabase tests that database spans are mapped to dependencies
+// with their type, target and statement
+func TestProcessDatabase(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Options []Option
+		Attr    []attribute.KeyValue
+
+		TelType      string
+		TelTarget    string
+		TelData      string
+		TelStatement string
+	}{
+		{
+			Name: "SQL database",
+			Attr: []attribute.KeyValue{
+				semconv.DBSystemKey.String("postgresql"),
+				semconv.DBNameKey.String("shop"),
+				semconv.DBStatementKey.String("SELECT * FROM users WHERE id = 42"),
+				semconv.NetPeerNameKey.String("db.internal"),
+				semconv.NetPeerPortKey.Int(5432),
+			},
+			TelType:   "SQL",
+			TelTarget: "db.internal:5432 | shop",
+			TelData:   "SELECT * FROM users WHERE id = 42",
+		},
+		{
+			Name:    "Sanitized SQL database",
+			Options: []Option{WithSanitizedStatements()},
+			Attr: []attribute.KeyValue{
+				semconv.DBSystemKey.String("mssql"),
+				semconv.DBNameKey.String("shop"),
+				semconv.DBStatementKey.String("SELECT * FROM users WHERE email = 'bob@example.com'"),
+				semconv.NetPeerNameKey.String("db.internal"),
+			},
+			TelType:   "SQL",
+			TelTarget: "db.internal | shop",
+			TelData:   "SELECT * FROM users WHERE email = ?",
+		},
+		{
+			Name:    "Redis without database name",
+			Options: []Option{WithSanitizedStatements()},
+			Attr: []attribute.KeyValue{
+				semconv.DBSystemKey.String("redis"),
+				semconv.DBStatementKey.String("GET session:42"),
+				semconv.NetPeerNameKey.String("cache.internal"),
+				semconv.NetPeerPortKey.Int(6379),
+			},
+			TelType:   "Redis",
+			TelTarget: "cache.internal:6379",
+			TelData:   "GET session:42",
+		},
+		{
+			Name:    "Sanitized HTTP database",
+			Options: []Option{WithSanitizedStatements()},
+			Attr: []attribute.KeyValue{
+				semconv.DBSystemKey.String("elasticsearch"),
+				semconv.DBStatementKey.String(`{"query":{"term":{"email":"bob@example.com"}}}`),
+				semconv.HTTPURLKey.String("http://search.internal:9200/users/_search"),
+			},
+			TelType:      "Elasticsearch",
+			TelTarget:    "search.internal:9200",
+			TelData:      "http://search.internal:9200/users/_search",
+			TelStatement: `{"query":{"term":{"email":"?"}}}`,
+		},
+		{
+			Name: "Database without host",
+			Attr: []attribute.KeyValue{
+				semconv.DBSystemKey.String("sqlite"),
+				semconv.DBNameKey.String("local.db"),
+			},
+			TelType:   "SQL",
+			TelTarget: "local.db",
+			TelData:   "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil, test.Options...)
+			exp.client = tcl
+
+			res, _ := resource.New(context.Background())
+			exp.process(&mockSpan{
+				name:   "query",
+				kind:   trace.SpanKindClient,
+				status: sdktrace.Status{Code: codes.Ok},
+				res:    res,
+				attr:   test.Attr,
+			})
+
+			assert.Equal(t, 1, len(tcl.tels))
+			tel := tcl.tels[0].(*appinsights.RemoteDependencyTelemetry)
+			assert.Equal(t, test.TelType, tel.Type)
+			assert.Equal(t, test.TelTarget, tel.Target)
+			assert.Equal(t, test.TelData, tel.Data)
+			if test.TelStatement == "" {
+				assert.NotContains(t, tel.Properties, "db.statement")
+			} else {
+				assert.Equal(t, test.TelStatement, tel.Properties["db.statement"])
+			}
+		})
+	}
+}
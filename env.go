@@ -0,0 +1,137 @@
+package apex
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+)
+
+// Environment variables read by NewExporterFromEnv.
+const (
+	EnvConnectionString   = "APPLICATIONINSIGHTS_CONNECTION_STRING"
+	EnvServiceName        = "OTEL_SERVICE_NAME"
+	EnvSamplingPercentage = "APPLICATIONINSIGHTS_SAMPLING_PERCENTAGE"
+	EnvEndpoint           = "APPLICATIONINSIGHTS_ENDPOINT"
+	EnvFlushInterval      = "APPLICATIONINSIGHTS_FLUSH_INTERVAL"
+)
+
+// ConfigError is returned when the exporter's configuration is invalid. It
+// holds every problem found, so that they can be fixed at once.
+type ConfigError struct {
+	Errors []error
+}
+
+// Error returns the messages of all problems with the configuration.
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the problems with the configuration.
+func (e *ConfigError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is checks if any problem with the configuration matches the target, since
+// errors.Is does not follow multiple wrapped errors before Go 1.20.
+func (e *ConfigError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first problem with the configuration that matches the target
+// and sets the target to it, since errors.As does not follow multiple wrapped
+// errors before Go 1.20.
+func (e *ConfigError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewExporterFromEnv creates a new App Insights Exporter configured from
+// environment variables. The exporter uses a logger function provided as a
+// callback for logging events. Options override the settings read from the
+// environment. All invalid variables are reported together in a ConfigError.
+//
+// EnvEndpoint is the base url of the ingestion endpoint, such as
+// "https://dc.services.visualstudio.com", like the IngestionEndpoint of a
+// connection string, which it overrides.
+func NewExporterFromEnv(
+	logger func(msg string) error,
+	opts ...Option,
+) (*AppInsightsExporter, error) {
+	var errs []error
+	var cs *connectionString
+	envOpts := []Option{}
+
+	if val := os.Getenv(EnvConnectionString); val == "" {
+		errs = append(errs, errors.New(EnvConnectionString+" is not set"))
+	} else if parsed, err := parseConnectionString(val); err != nil {
+		errs = append(errs, errors.New(EnvConnectionString+": "+err.Error()))
+	} else {
+		cs = parsed
+		envOpts = append(envOpts, withLiveEndpoint(cs.liveEndpoint))
+	}
+
+	if val := os.Getenv(EnvServiceName); val != "" {
+		envOpts = append(envOpts, WithServiceName(val))
+	}
+
+	if val := os.Getenv(EnvSamplingPercentage); val != "" {
+		p, err := strconv.ParseFloat(val, 64)
+		if err != nil || p < 0 || p > 100 {
+			errs = append(errs, errors.New(
+				EnvSamplingPercentage+": "+val+" is not a percentage between 0 and 100",
+			))
+		} else {
+			envOpts = append(envOpts, WithSamplingPercentage(p))
+		}
+	}
+
+	endpoint := os.Getenv(EnvEndpoint)
+	if endpoint != "" {
+		if err := validateURL(endpoint); err != nil {
+			errs = append(errs, errors.New(EnvEndpoint+": "+err.Error()))
+		}
+	}
+
+	var interval time.Duration
+	if val := os.Getenv(EnvFlushInterval); val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			errs = append(errs, errors.New(
+				EnvFlushInterval+": "+val+" is not a positive duration",
+			))
+		} else {
+			interval = d
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+
+	if endpoint != "" {
+		cs.ingestionEndpoint = strings.TrimRight(endpoint, "/")
+	}
+	cfg := appinsights.NewTelemetryConfiguration(cs.instrumentationKey)
+	cfg.EndpointUrl = cs.trackEndpoint()
+	if interval > 0 {
+		cfg.MaxBatchInterval = interval
+	}
+	return NewExporterFromConfig(cfg, logger, append(envOpts, opts...)...)
+}
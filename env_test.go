@@ -0,0 +1,128 @@
+package apex
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNewExporterFromEnv tests that exporters are configured from environment
+// variables and that all invalid variables are reported
+func TestNewExporterFromEnv(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Env      map[string]string
+		Opts     []Option
+		Errors   int
+		Endpoint string
+		Interval time.Duration
+		Service  string
+		Sampling float64
+	}{
+		{
+			Name: "Connection string only",
+			Env: map[string]string{
+				EnvConnectionString: "InstrumentationKey=key",
+			},
+			Endpoint: defaultEndpoint,
+			Interval: 10 * time.Second,
+			Service:  "unknown-service",
+			Sampling: 100,
+		},
+		{
+			Name: "All variables",
+			Env: map[string]string{
+				EnvConnectionString:   "InstrumentationKey=key;EndpointSuffix=applicationinsights.azure.cn",
+				EnvServiceName:        "checkout",
+				EnvSamplingPercentage: "25",
+				EnvEndpoint:           "http://localhost:8080/",
+				EnvFlushInterval:      "2s",
+			},
+			Endpoint: "http://localhost:8080/v2.1/track",
+			Interval: 2 * time.Second,
+			Service:  "checkout",
+			Sampling: 25,
+		},
+		{
+			Name: "Options override variables",
+			Env: map[string]string{
+				EnvConnectionString:   "InstrumentationKey=key;EndpointSuffix=applicationinsights.azure.cn",
+				EnvServiceName:        "checkout",
+				EnvSamplingPercentage: "25",
+			},
+			Opts:     []Option{WithServiceName("payments"), WithSamplingPercentage(50)},
+			Endpoint: "https://dc.applicationinsights.azure.cn/v2.1/track",
+			Interval: 10 * time.Second,
+			Service:  "payments",
+			Sampling: 50,
+		},
+		{
+			Name:   "Missing connection string",
+			Env:    map[string]string{},
+			Errors: 1,
+		},
+		{
+			Name: "Invalid variables",
+			Env: map[string]string{
+				EnvConnectionString:   "IngestionEndpoint=https://example.com",
+				EnvSamplingPercentage: "150",
+				EnvEndpoint:           "localhost",
+				EnvFlushInterval:      "10",
+			},
+			Errors: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			for _, key := range []string{
+				EnvConnectionString, EnvServiceName, EnvSamplingPercentage,
+				EnvEndpoint, EnvFlushInterval,
+			} {
+				t.Setenv(key, test.Env[key])
+			}
+
+			exp, err := NewExporterFromEnv(nil, test.Opts...)
+			if test.Errors > 0 {
+				cerr := &ConfigError{}
+				assert.True(t, errors.As(err, &cerr))
+				assert.Equal(t, test.Errors, len(cerr.Errors))
+				assert.Nil(t, exp)
+				return
+			}
+			assert.Nil(t, err)
+
+			ch := exp.client.Channel().(*batchChannel)
+			defer exp.Shutdown(context.Background())
+			assert.Equal(t, test.Endpoint, ch.transmitter.endpoint)
+			assert.Equal(t, test.Interval, ch.maxBatchInterval)
+			assert.Equal(t, test.Service, exp.serviceName)
+			assert.Equal(t, test.Sampling, exp.sampling)
+		})
+	}
+}
+
+// TestConfigErrorIs tests that the problems of a configuration error are
+// matched by errors.Is and errors.As
+func TestConfigErrorIs(t *testing.T) {
+	errMissing := errors.New("missing")
+	err := error(&ConfigError{Errors: []error{
+		errMissing,
+		&ConfigError{Errors: []error{errors.New("nested")}},
+	}})
+
+	assert.True(t, errors.Is(err, errMissing))
+	assert.False(t, errors.Is(err, errors.New("missing")))
+	assert.True(t, (&ConfigError{Errors: []error{errMissing}}).Is(errMissing))
+
+	var nested *ConfigError
+	assert.True(t, err.(*ConfigError).As(&nested))
+	assert.Equal(t, "invalid configuration: nested", nested.Error())
+
+	var target *url.Error
+	assert.False(t, err.(*ConfigError).As(&target))
+}
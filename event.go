@@ -0,0 +1,61 @@
+package apex
+
+import (
+	"context"
+	"errors"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TrackEvent sends a custom event with properties and measurements to
+// Application Insights, correlated with the span in the context so that it
+// appears under the same operation. The span is the parent of the event,
+// and the event has the cloud role of the span's resource.
+//
+// Events of traces that the exporter's sampling drops are dropped as well.
+// Events can not be tracked after the exporter is shut down.
+func (exp *AppInsightsExporter) TrackEvent(
+	ctx context.Context,
+	name string,
+	properties map[string]string,
+	measurements map[string]float64,
+) error {
+	exp.mtx.RLock()
+	defer exp.mtx.RUnlock()
+	if exp.closed {
+		return errors.New("exporter closed")
+	}
+
+	tele := appinsights.NewEventTelemetry(name)
+	for k, v := range properties {
+		tele.Properties[k] = v
+	}
+	for k, v := range measurements {
+		tele.Measurements[k] = v
+	}
+	tele.Tags.Cloud().SetRole(exp.serviceName)
+
+	span := trace.SpanFromContext(ctx)
+	if sc := span.SpanContext(); sc.IsValid() {
+		opId := operationId(sc)
+		if exp.sampling < 100 && samplingScore(opId) >= exp.sampling {
+			return nil
+		}
+		tele.Tags.Operation().SetId(opId)
+		tele.Tags.Operation().SetParentId(sc.SpanID().String())
+	}
+	if sp, ok := span.(sdktrace.ReadOnlySpan); ok {
+		tele.Tags.Operation().SetName(sp.Name())
+		for _, kv := range sp.Resource().Attributes() {
+			if kv.Key == semconv.ServiceNameKey {
+				tele.Tags.Cloud().SetRole(kv.Value.AsString())
+			}
+		}
+	}
+
+	exp.track(tele)
+	return nil
+}
@@ -0,0 +1,126 @@
+package apex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestTrackEvent tests that custom events are correlated with the span in
+// the context and carry their properties and measurements
+func TestTrackEvent(t *testing.T) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithResource(
+		resource.NewSchemaless(semconv.ServiceNameKey.String("checkout")),
+	))
+	ctx, span := tp.Tracer("test").Start(context.Background(), "POST /orders")
+	defer span.End()
+	sc := span.SpanContext()
+
+	remote := trace.ContextWithRemoteSpanContext(
+		context.Background(),
+		trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: sc.TraceID(),
+			SpanID:  sc.SpanID(),
+			Remote:  true,
+		}),
+	)
+
+	tests := []struct {
+		Name   string
+		Ctx    context.Context
+		Closed bool
+
+		Error        bool
+		TelOperation string
+		TelParent    string
+		TelName      string
+		TelRole      string
+	}{
+		{
+			Name:         "Recording span",
+			Ctx:          ctx,
+			TelOperation: sc.TraceID().String(),
+			TelParent:    sc.SpanID().String(),
+			TelName:      "POST /orders",
+			TelRole:      "checkout",
+		},
+		{
+			Name:         "Remote span context",
+			Ctx:          remote,
+			TelOperation: sc.TraceID().String(),
+			TelParent:    sc.SpanID().String(),
+			TelRole:      "unknown-service",
+		},
+		{
+			Name:    "No span",
+			Ctx:     context.Background(),
+			TelRole: "unknown-service",
+		},
+		{
+			Name:   "Closed exporter",
+			Ctx:    ctx,
+			Closed: true,
+			Error:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil)
+			exp.client = tcl
+			exp.closed = test.Closed
+
+			err := exp.TrackEvent(
+				test.Ctx,
+				"order placed",
+				map[string]string{"plan": "pro"},
+				map[string]float64{"total": 42.5},
+			)
+			if test.Error {
+				assert.Error(t, err)
+				assert.Equal(t, 0, len(tcl.tels))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(tcl.tels))
+			assert.Equal(t, uint64(1), exp.Stats().EventsTracked)
+
+			tel := tcl.tels[0].(*appinsights.EventTelemetry)
+			assert.Equal(t, "order placed", tel.Name)
+			assert.Equal(t, "pro", tel.Properties["plan"])
+			assert.Equal(t, 42.5, tel.Measurements["total"])
+			assert.Equal(t, test.TelOperation, tel.Tags.Operation().GetId())
+			assert.Equal(t, test.TelParent, tel.Tags.Operation().GetParentId())
+			assert.Equal(t, test.TelName, tel.Tags.Operation().GetName())
+			assert.Equal(t, test.TelRole, tel.Tags.Cloud().GetRole())
+		})
+	}
+}
+
+// TestTrackEventSampling tests that events of traces dropped by sampling
+// are dropped as well
+func TestTrackEventSampling(t *testing.T) {
+	tcl := &mockTelemetryClient{}
+	exp, _ := NewExporter("", nil, WithSamplingPercentage(0))
+	exp.client = tcl
+
+	ctx := trace.ContextWithSpanContext(
+		context.Background(),
+		trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: [16]byte{1},
+			SpanID:  [8]byte{2},
+		}),
+	)
+	assert.NoError(t, exp.TrackEvent(ctx, "order placed", nil, nil))
+	assert.Equal(t, 0, len(tcl.tels))
+
+	assert.NoError(t, exp.TrackEvent(context.Background(), "order placed", nil, nil))
+	assert.Equal(t, 1, len(tcl.tels))
+}
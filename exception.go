@@ -0,0 +1,96 @@
+package apex
+
+import (
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// exceptionTelemetry is an exception telemetry item of an exception recorded
+// on a span. appinsights.ExceptionTelemetry derives the type of exceptions
+// from Go values and their stack from the current goroutine, while recorded
+// exceptions have their type and stack trace as attributes.
+type exceptionTelemetry struct {
+	appinsights.ExceptionTelemetry
+	TypeName string
+	Message  string
+	Stack    string
+}
+
+// TelemetryData returns the data of the exception that is sent to
+// Application Insights.
+func (t *exceptionTelemetry) TelemetryData() appinsights.TelemetryData {
+	data := t.ExceptionTelemetry.TelemetryData().(*contracts.ExceptionData)
+	for _, details := range data.Exceptions {
+		details.TypeName = t.TypeName
+		details.Message = t.Message
+		details.Stack = t.Stack
+		details.HasFullStack = t.Stack != ""
+	}
+	return data
+}
+
+// processExceptionEvents constructs an exception telemetry for each exception
+// recorded on the span, and dispatches them to the application insights
+// telemetry client. The exceptions are correlated to the span as their
+// parent.
+//
+// Application Insights specific fields are sourced from the event's
+// attributes and the span's resource:
+// TypeName = attributes["exception.type"]
+// Message = attributes["exception.message"]
+// Stack = attributes["exception.stacktrace"]
+// Role = resource["service.name"]
+//
+// Other attributes of the event are added as properties.
+func (exp *AppInsightsExporter) processExceptionEvents(
+	sp sdktrace.ReadOnlySpan,
+) {
+	for _, e := range sp.Events() {
+		if e.Name != semconv.ExceptionEventName {
+			continue
+		}
+
+		tele := exceptionTelemetry{
+			ExceptionTelemetry: appinsights.ExceptionTelemetry{
+				SeverityLevel: contracts.Error,
+				BaseTelemetry: appinsights.BaseTelemetry{
+					Timestamp:  e.Time,
+					Tags:       make(contracts.ContextTags),
+					Properties: map[string]string{},
+				},
+				BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
+					Measurements: map[string]float64{},
+				},
+			},
+			TypeName: "<unknown>",
+		}
+		for _, a := range e.Attributes {
+			switch a.Key {
+			case semconv.ExceptionTypeKey:
+				tele.TypeName = a.Value.AsString()
+			case semconv.ExceptionMessageKey:
+				tele.Message = a.Value.AsString()
+			case semconv.ExceptionStacktraceKey:
+				tele.Stack = a.Value.AsString()
+			default:
+				tele.Properties[string(a.Key)] = a.Value.Emit()
+			}
+		}
+		tele.Error = tele.Message
+
+		tele.Tags.Cloud().SetRole(exp.serviceName)
+		for _, e := range sp.Resource().Attributes() {
+			if e.Key == semconv.ServiceNameKey {
+				tele.Tags.Cloud().SetRole(e.Value.AsString())
+			}
+		}
+
+		tele.Tags.Operation().SetId(operationId(sp.SpanContext()))
+		tele.Tags.Operation().SetParentId(sp.SpanContext().SpanID().String())
+		tele.Tags.Operation().SetName(sp.Name())
+
+		exp.track(&tele)
+	}
+}
@@ -0,0 +1,128 @@
+package apex
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestProcessExceptionEvents tests that exceptions recorded on spans are
+// mapped to exception telemetry with their type, message and stack trace
+func TestProcessExceptionEvents(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		Name  string
+		Attr  []attribute.KeyValue
+		Other bool
+
+		TelType       string
+		TelMessage    string
+		TelStack      string
+		TelProperties map[string]string
+	}{
+		{
+			Name: "Recorded error",
+			Attr: []attribute.KeyValue{
+				semconv.ExceptionTypeKey.String("*errors.errorString"),
+				semconv.ExceptionMessageKey.String("connection refused"),
+				semconv.ExceptionStacktraceKey.String("goroutine 1 [running]:\nmain.main()"),
+				semconv.ExceptionEscapedKey.Bool(true),
+			},
+			TelType:       "*errors.errorString",
+			TelMessage:    "connection refused",
+			TelStack:      "goroutine 1 [running]:\nmain.main()",
+			TelProperties: map[string]string{"exception.escaped": "true"},
+		},
+		{
+			Name: "Message only",
+			Attr: []attribute.KeyValue{
+				semconv.ExceptionMessageKey.String("panic: nil map"),
+			},
+			TelType:       "<unknown>",
+			TelMessage:    "panic: nil map",
+			TelProperties: map[string]string{},
+		},
+		{
+			Name:  "Other event",
+			Other: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil)
+			exp.client = tcl
+
+			name := semconv.ExceptionEventName
+			if test.Other {
+				name = "message"
+			}
+
+			res, _ := resource.New(
+				context.Background(),
+				resource.WithAttributes(semconv.ServiceNameKey.String("test")),
+			)
+			exp.process(&mockSpan{
+				name:      "GET /orders",
+				kind:      trace.SpanKindServer,
+				status:    sdktrace.Status{Code: codes.Error},
+				startTime: start,
+				endTime:   start.Add(time.Second),
+				traceId:   [16]byte{1},
+				spanId:    [8]byte{2},
+				res:       res,
+				attr:      []attribute.KeyValue{},
+				events: []sdktrace.Event{{
+					Name:       name,
+					Attributes: test.Attr,
+					Time:       start.Add(time.Millisecond),
+				}},
+			})
+
+			if test.Other {
+				assert.Equal(t, 1, len(tcl.tels))
+				assert.Equal(t, uint64(0), exp.Stats().ExceptionsTracked)
+				return
+			}
+			assert.Equal(t, 2, len(tcl.tels))
+			tel, ok := tcl.tels[1].(*exceptionTelemetry)
+			assert.True(t, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, uint64(1), exp.Stats().ExceptionsTracked)
+			assert.Equal(t, start.Add(time.Millisecond), tel.Timestamp)
+			assert.Equal(t, test.TelProperties, tel.Properties)
+			assert.Equal(t, "test", tel.Tags.Cloud().GetRole())
+			assert.Equal(t, "01000000000000000000000000000000", tel.Tags.Operation().GetId())
+			assert.Equal(t, "0200000000000000", tel.Tags.Operation().GetParentId())
+			assert.Equal(t, "GET /orders", tel.Tags.Operation().GetName())
+
+			data, _ := json.Marshal(tel.TelemetryData())
+			exc := struct {
+				Exceptions []struct {
+					TypeName     string `json:"typeName"`
+					Message      string `json:"message"`
+					Stack        string `json:"stack"`
+					HasFullStack bool   `json:"hasFullStack"`
+				} `json:"exceptions"`
+			}{}
+			assert.Nil(t, json.Unmarshal(data, &exc))
+			assert.Equal(t, 1, len(exc.Exceptions))
+			assert.Equal(t, test.TelType, exc.Exceptions[0].TypeName)
+			assert.Equal(t, test.TelMessage, exc.Exceptions[0].Message)
+			assert.Equal(t, test.TelStack, exc.Exceptions[0].Stack)
+			assert.Equal(t, test.TelStack != "", exc.Exceptions[0].HasFullStack)
+		})
+	}
+}
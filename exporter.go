@@ -14,10 +14,36 @@ import (
 	trace "go.opentelemetry.io/otel/trace"
 )
 
+// statusDescriptionKey is the property key under which the description of
+// the span's status is recorded.
+const statusDescriptionKey = "otel.status_description"
+
+// Measurement keys under which the number of attributes, events and links
+// dropped from the span due to span limits are recorded.
+const (
+	droppedAttributesKey = "otel.dropped_attributes_count"
+	droppedEventsKey     = "otel.dropped_events_count"
+	droppedLinksKey      = "otel.dropped_links_count"
+)
+
 type AppInsightsExporter struct {
-	client appinsights.TelemetryClient
-	mtx    *sync.RWMutex
-	closed bool
+	client             appinsights.TelemetryClient
+	mtx                *sync.RWMutex
+	closed             bool
+	filters            []Filter
+	pageViews          []Filter
+	scopeNameKey       string
+	scopeVersionKey    string
+	statusExceptions   bool
+	serviceName        string
+	sampling           float64
+	legacyParentIds    bool
+	sanitizeStatements bool
+	syntheticAgents    []string
+	syntheticHeaders   []string
+	appIds             *appIdCache
+	live               *liveMetrics
+	counters           counters
 }
 
 // NewExporter creates a new App Insights Exporter with an app insights
@@ -26,14 +52,11 @@ type AppInsightsExporter struct {
 func NewExporter(
 	instrumentationKey string,
 	logger func(msg string) error,
+	opts ...Option,
 ) (*AppInsightsExporter, error) {
-	client := appinsights.NewTelemetryClient(instrumentationKey)
-	appinsights.NewDiagnosticsMessageListener(logger)
-	return &AppInsightsExporter{
-		client: client,
-		mtx:    &sync.RWMutex{},
-		closed: false,
-	}, nil
+	cfg := appinsights.NewTelemetryConfiguration(instrumentationKey)
+	cfg.EndpointUrl = defaultEndpoint
+	return NewExporterFromConfig(cfg, logger, opts...)
 }
 
 // NewExporterFromConfig creates a new App Insights Exporter with an app
@@ -42,18 +65,124 @@ func NewExporter(
 func NewExporterFromConfig(
 	cfg *appinsights.TelemetryConfiguration,
 	logger func(msg string) error,
+	opts ...Option,
 ) (*AppInsightsExporter, error) {
 	if cfg == nil {
 		return nil, errors.New("configuration is nil")
 	}
 
-	client := appinsights.NewTelemetryClientFromConfig(cfg)
-	appinsights.NewDiagnosticsMessageListener(logger)
+	conf := newConfig(opts)
+	if conf.sampling < 0 || conf.sampling > 100 {
+		return nil, errors.New("sampling percentage is out of range")
+	}
+
+	var store *diskStore
+	if conf.storage != nil {
+		var err error
+		if store, err = newDiskStore(conf.storage); err != nil {
+			return nil, err
+		}
+	}
+
+	hc, err := conf.transport.httpClient(cfg.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &transmitter{
+		endpoint: cfg.EndpointUrl,
+		client:   hc,
+	}
+	if tr.endpoint == "" {
+		tr.endpoint = defaultEndpoint
+	}
+	if conf.tokenSource != nil {
+		tr.tokens = newTokenCache(conf.tokenSource)
+	}
+
+	channel := newBatchChannel(
+		tr, store, logger, cfg.MaxBatchSize, cfg.MaxBatchInterval,
+	)
+	client := newTelemetryClient(cfg.InstrumentationKey, channel, logger)
+	client.sampleRate = conf.sampling
+
+	exp := newExporter(client, conf)
+	if conf.liveMetrics {
+		exp.live = newLiveMetrics(
+			conf.liveEndpoint, cfg.InstrumentationKey, conf.serviceName,
+			hc, tr.tokens, logger,
+		)
+		exp.live.start()
+	}
+	return exp, nil
+}
+
+// NewExporterWithClient creates a new App Insights Exporter that dispatches
+// telemetry to the provided telemetry client, such as a client with a custom
+// channel that buffers, fans out or records telemetry. The client's channel
+// is closed when the exporter is shut down. The exporter uses a logger
+// function provided as a callback for logging events of live metrics.
+//
+// Options that configure how telemetry is transmitted, such as offline
+// storage, have no effect on the client. Sampling is not supported, since
+// the client would not report the sample rate of the telemetry and counts
+// in Application Insights would be skewed.
+func NewExporterWithClient(
+	client appinsights.TelemetryClient,
+	logger func(msg string) error,
+	opts ...Option,
+) (*AppInsightsExporter, error) {
+	if client == nil {
+		return nil, errors.New("telemetry client is nil")
+	}
+
+	conf := newConfig(opts)
+	if conf.sampling != 100 {
+		return nil, errors.New("sampling is not supported with a custom client")
+	}
+
+	exp := newExporter(client, conf)
+	if conf.liveMetrics {
+		hc, err := conf.transport.httpClient(nil)
+		if err != nil {
+			return nil, err
+		}
+		var tokens *tokenCache
+		if conf.tokenSource != nil {
+			tokens = newTokenCache(conf.tokenSource)
+		}
+		exp.live = newLiveMetrics(
+			conf.liveEndpoint, client.InstrumentationKey(), conf.serviceName,
+			hc, tokens, logger,
+		)
+		exp.live.start()
+	}
+	return exp, nil
+}
+
+// newExporter creates an App Insights Exporter that dispatches telemetry to
+// the client, configured from the options.
+func newExporter(
+	client appinsights.TelemetryClient,
+	cfg *config,
+) *AppInsightsExporter {
 	return &AppInsightsExporter{
-		client: client,
-		mtx:    &sync.RWMutex{},
-		closed: false,
-	}, nil
+		client:             client,
+		mtx:                &sync.RWMutex{},
+		closed:             false,
+		filters:            cfg.filters,
+		pageViews:          cfg.pageViews,
+		scopeNameKey:       cfg.scopeNameKey,
+		scopeVersionKey:    cfg.scopeVersionKey,
+		statusExceptions:   cfg.statusExceptions,
+		serviceName:        cfg.serviceName,
+		sampling:           cfg.sampling,
+		legacyParentIds:    cfg.legacyParentIds,
+		sanitizeStatements: cfg.sanitizeStatements,
+		syntheticAgents:    cfg.syntheticAgents,
+		syntheticHeaders:   cfg.syntheticHeaders,
+		appIds:             newAppIdCache(),
+	}
 }
 
 // ExportSpans processes and dispatches an array of Open Telemetry spans
@@ -65,11 +194,26 @@ func (exp *AppInsightsExporter) ExportSpans(
 	exp.mtx.RLock()
 	defer exp.mtx.RUnlock()
 
+	exp.counters.spansReceived.Add(uint64(len(spans)))
 	if exp.closed {
+		exp.counters.exportErrors.Add(1)
 		return errors.New("exporter closed")
 	}
 
+	start := time.Now()
+	defer exp.counters.observeExport(start)
+
 	for i := range spans {
+		if exp.filter(spans[i]) {
+			exp.counters.spansFiltered.Add(1)
+			continue
+		}
+		// Availability results are kept regardless of sampling, like the
+		// results tracked with TrackAvailability
+		if !isAvailabilityTest(spans[i]) && !exp.sample(spans[i]) {
+			exp.counters.spansSampledOut.Add(1)
+			continue
+		}
 		exp.process(spans[i])
 	}
 	return nil
@@ -84,6 +228,9 @@ func (exp *AppInsightsExporter) Shutdown(
 	exp.mtx.Lock()
 	defer exp.mtx.Unlock()
 	exp.closed = true
+	if exp.live != nil {
+		exp.live.stop()
+	}
 
 	select {
 	case <-exp.client.Channel().Close(time.Minute):
@@ -93,6 +240,17 @@ func (exp *AppInsightsExporter) Shutdown(
 	}
 }
 
+// filter checks if the span matches any of the exporter's filters and should
+// be dropped instead of being exported.
+func (exp *AppInsightsExporter) filter(sp sdktrace.ReadOnlySpan) bool {
+	for i := range exp.filters {
+		if exp.filters[i].match(sp) {
+			return true
+		}
+	}
+	return false
+}
+
 // processInternal constructs a telemetry for an internal event and dispatches
 // it to the application insights telemetry client.
 //
@@ -101,6 +259,7 @@ func (exp *AppInsightsExporter) Shutdown(
 func (exp *AppInsightsExporter) processInternal(
 	sp sdktrace.ReadOnlySpan,
 	properties map[string]string,
+	measurements map[string]float64,
 ) {
 	tele := appinsights.EventTelemetry{
 		Name: sp.Name(),
@@ -110,27 +269,24 @@ func (exp *AppInsightsExporter) processInternal(
 			Properties: map[string]string{},
 		},
 		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: map[string]float64{},
+			Measurements: measurements,
 		},
 	}
 
-	pid := sp.Parent().SpanID().String()
-	if pid == "0000000000000000" {
-		pid = sp.SpanContext().TraceID().String()
-	}
+	pid := exp.parentId(sp)
 
-	tele.Tags.Cloud().SetRole("unknown-service")
+	tele.Tags.Cloud().SetRole(exp.serviceName)
 	if val, ok := properties[string(semconv.ServiceNameKey)]; ok {
 		delete(properties, string(semconv.ServiceNameKey))
 		tele.Tags.Cloud().SetRole(val)
 	}
 	tele.BaseTelemetry.Properties = properties
 
-	tele.Tags.Operation().SetId(sp.SpanContext().TraceID().String())
+	tele.Tags.Operation().SetId(operationId(sp.SpanContext()))
 	tele.Tags.Operation().SetParentId(pid)
 	tele.Tags.Operation().SetName(sp.Name())
 
-	exp.client.Track(&tele)
+	exp.track(&tele)
 }
 
 // processRequest constructs the telemetry for an incoming http request
@@ -139,11 +295,13 @@ func (exp *AppInsightsExporter) processInternal(
 // Application Insights specific fields are sourced from custom properties:
 // Role = properties["service.name"]
 // Url = properties["url"]
-// ResponseCode = properties["responseCode"]
+// Source = the application id in properties["http.request.header.request-context"]
+// ResponseCode = properties["responseCode"], or the http or grpc status code
 func (exp *AppInsightsExporter) processRequest(
 	sp sdktrace.ReadOnlySpan,
 	success bool,
 	properties map[string]string,
+	measurements map[string]float64,
 ) {
 	tele := appinsights.RequestTelemetry{
 		Name:         sp.Name(),
@@ -158,10 +316,10 @@ func (exp *AppInsightsExporter) processRequest(
 			Properties: map[string]string{},
 		},
 		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: map[string]float64{},
+			Measurements: measurements,
 		},
 	}
-	tele.Tags.Cloud().SetRole("unknown-service")
+	tele.Tags.Cloud().SetRole(exp.serviceName)
 	if val, ok := properties[string(semconv.ServiceNameKey)]; ok {
 		delete(properties, string(semconv.ServiceNameKey))
 		tele.Tags.Cloud().SetRole(val)
@@ -170,22 +328,33 @@ func (exp *AppInsightsExporter) processRequest(
 		delete(properties, "url")
 		tele.Url = val
 	}
+	tele.Source = requestSource(properties)
+	synthetic := exp.syntheticSource(sp, properties)
 	if val, ok := properties["responseCode"]; ok {
 		delete(properties, "responseCode")
 		tele.ResponseCode = val
+	} else if val := statusCode(sp); val != "" {
+		tele.ResponseCode = val
+	}
+	if desc := sp.Status().Description; desc != "" {
+		properties[statusDescriptionKey] = desc
 	}
 	tele.BaseTelemetry.Properties = properties
 
-	pid := sp.Parent().SpanID().String()
-	if pid == "0000000000000000" {
-		pid = sp.SpanContext().TraceID().String()
-	}
+	pid := exp.parentId(sp)
 
-	tele.Tags.Operation().SetId(sp.SpanContext().TraceID().String())
+	tele.Tags.Operation().SetId(operationId(sp.SpanContext()))
 	tele.Tags.Operation().SetParentId(pid)
 	tele.Tags.Operation().SetName(sp.Name())
+	if synthetic != "" {
+		tele.Tags.Operation().SetSyntheticSource(synthetic)
+	}
+	if synthetic == availabilitySource {
+		tele.Tags.Session().SetId(properties[syntheticRunIdAttribute])
+		tele.Tags.User().SetId(properties[syntheticLocationAttribute])
+	}
 
-	exp.client.Track(&tele)
+	exp.track(&tele)
 }
 
 // processEvent constructs the telemetry for an incoming event to be handled
@@ -194,11 +363,13 @@ func (exp *AppInsightsExporter) processRequest(
 // Application Insights specific fields are sourced from custom properties:
 // Role = properties["service.name"]
 // Url = properties["key"]
-// ResponseCode = properties["responseCode"]
+// Source = properties["messaging.destination"]
+// ResponseCode = properties["responseCode"], or the http or grpc status code
 func (exp *AppInsightsExporter) processEvent(
 	sp sdktrace.ReadOnlySpan,
 	success bool,
 	properties map[string]string,
+	measurements map[string]float64,
 ) {
 	tele := appinsights.RequestTelemetry{
 		Name:         sp.Name(),
@@ -213,10 +384,10 @@ func (exp *AppInsightsExporter) processEvent(
 			Properties: map[string]string{},
 		},
 		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: map[string]float64{},
+			Measurements: measurements,
 		},
 	}
-	tele.Tags.Cloud().SetRole("unknown-service")
+	tele.Tags.Cloud().SetRole(exp.serviceName)
 	if val, ok := properties[string(semconv.ServiceNameKey)]; ok {
 		delete(properties, string(semconv.ServiceNameKey))
 		tele.Tags.Cloud().SetRole(val)
@@ -225,35 +396,51 @@ func (exp *AppInsightsExporter) processEvent(
 		delete(properties, "key")
 		tele.Url = val
 	}
+	tele.Source = messagingDestination(properties)
 	if val, ok := properties["responseCode"]; ok {
 		delete(properties, "responseCode")
 		tele.ResponseCode = val
+	} else if val := statusCode(sp); val != "" {
+		tele.ResponseCode = val
+	}
+	if desc := sp.Status().Description; desc != "" {
+		properties[statusDescriptionKey] = desc
 	}
 	tele.BaseTelemetry.Properties = properties
 
-	pid := sp.Parent().SpanID().String()
-	if pid == "0000000000000000" {
-		pid = sp.SpanContext().TraceID().String()
-	}
+	pid := exp.parentId(sp)
 
-	tele.Tags.Operation().SetId(sp.SpanContext().TraceID().String())
+	tele.Tags.Operation().SetId(operationId(sp.SpanContext()))
 	tele.Tags.Operation().SetParentId(pid)
 	tele.Tags.Operation().SetName(sp.Name())
 
-	exp.client.Track(&tele)
+	exp.track(&tele)
 }
 
 // processDependency constructs the telemetry for an outgoing dependency
 // and and dispatches it to the application insights telemetry client.
 //
 // Application Insights specific fields are sourced from custom properties:
-// Role = properties["source"]
+// Role = properties["source"] or properties["service.name"]
 // Type = properties["type"]
 // Target = properties["service.name"]
+// ResultCode = properties["responseCode"], or the http or grpc status code
+// Data = properties["url"], properties["http.url"] or properties["db.statement"]
+//
+// Statements are sanitized when enabled, also when they remain in the
+// properties because the data is sourced from the url.
+//
+// If the type is not set, it is selected from semantic convention attributes
+// or the span's instrumentation scope. The target is the called host when
+// the span has peer or url attributes, followed by the application id of the
+// called resource when it is known from a Request-Context response header.
+// Database dependencies target the server and database name instead, and
+// messaging dependencies target the queue or topic.
 func (exp *AppInsightsExporter) processDependency(
 	sp sdktrace.ReadOnlySpan,
 	success bool,
 	properties map[string]string,
+	measurements map[string]float64,
 ) {
 	tele := appinsights.RemoteDependencyTelemetry{
 		Name:     sp.Name(),
@@ -268,35 +455,177 @@ func (exp *AppInsightsExporter) processDependency(
 			Properties: map[string]string{},
 		},
 		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: map[string]float64{},
+			Measurements: measurements,
 		},
 	}
-	tele.Tags.Cloud().SetRole("unknown-service")
+	tele.Tags.Cloud().SetRole(exp.serviceName)
 	if val, ok := properties["source"]; ok {
 		delete(properties, "source")
 		tele.Tags.Cloud().SetRole(val)
+	} else if val, ok := properties[string(semconv.ServiceNameKey)]; ok {
+		tele.Tags.Cloud().SetRole(val)
 	}
 	if val, ok := properties["type"]; ok {
 		delete(properties, "type")
 		tele.Type = val
+	} else {
+		tele.Type = dependencyType(sp.InstrumentationScope().Name, properties)
 	}
 	tele.Target = "unknown-target"
 	if val, ok := properties[string(semconv.ServiceNameKey)]; ok {
 		delete(properties, string(semconv.ServiceNameKey))
 		tele.Target = val
 	}
+	host := dependencyHost(sp)
+	if host != "" {
+		tele.Target = host
+	}
+	system, isDB := properties[string(semconv.DBSystemKey)]
+	if target := dbTarget(sp); isDB && target != "" {
+		tele.Target = target
+	}
+	if dest := messagingDestination(properties); dest != "" {
+		tele.Target = dest
+	}
+	delete(properties, requestContextAttribute)
+	if appId := exp.appIds.resolve(host, requestContext(sp)); appId != "" {
+		tele.Target = dependencyTarget(tele.Target, appId)
+	}
+	if val, ok := properties["responseCode"]; ok {
+		delete(properties, "responseCode")
+		tele.ResultCode = val
+	} else if val := statusCode(sp); val != "" {
+		tele.ResultCode = val
+	}
+	statement, hasStatement := properties[string(semconv.DBStatementKey)]
+	if hasStatement && exp.sanitizeStatements {
+		statement = sanitizeStatement(system, statement)
+		properties[string(semconv.DBStatementKey)] = statement
+	}
+	if val, ok := properties["url"]; ok {
+		delete(properties, "url")
+		tele.Data = val
+	} else if val, ok := properties[string(semconv.HTTPURLKey)]; ok {
+		tele.Data = val
+	} else if hasStatement {
+		delete(properties, string(semconv.DBStatementKey))
+		tele.Data = statement
+	}
+	if desc := sp.Status().Description; desc != "" {
+		properties[statusDescriptionKey] = desc
+	}
 	tele.BaseTelemetry.Properties = properties
 
-	pid := sp.Parent().SpanID().String()
-	if pid == "0000000000000000" {
-		pid = sp.SpanContext().TraceID().String()
-	}
+	pid := exp.parentId(sp)
 
-	tele.Tags.Operation().SetId(sp.SpanContext().TraceID().String())
+	tele.Tags.Operation().SetId(operationId(sp.SpanContext()))
 	tele.Tags.Operation().SetParentId(pid)
 	tele.Tags.Operation().SetName(sp.Name())
 
-	exp.client.Track(&tele)
+	exp.track(&tele)
+}
+
+// processStatusException constructs an exception telemetry from the status
+// of a failed span and dispatches it to the application insights telemetry
+// client. The exception is correlated to the span as its parent.
+//
+// Application Insights specific fields are sourced from the span's resource:
+// Role = resource["service.name"]
+func (exp *AppInsightsExporter) processStatusException(
+	sp sdktrace.ReadOnlySpan,
+) {
+	msg := sp.Status().Description
+	if msg == "" {
+		msg = codes.Error.String()
+	}
+
+	tele := appinsights.ExceptionTelemetry{
+		Error:         msg,
+		SeverityLevel: contracts.Error,
+		BaseTelemetry: appinsights.BaseTelemetry{
+			Timestamp:  sp.EndTime(),
+			Tags:       make(contracts.ContextTags),
+			Properties: map[string]string{},
+		},
+		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
+			Measurements: map[string]float64{},
+		},
+	}
+
+	tele.Tags.Cloud().SetRole(exp.serviceName)
+	for _, e := range sp.Resource().Attributes() {
+		if e.Key == semconv.ServiceNameKey {
+			tele.Tags.Cloud().SetRole(e.Value.AsString())
+		}
+	}
+
+	tele.Tags.Operation().SetId(operationId(sp.SpanContext()))
+	tele.Tags.Operation().SetParentId(sp.SpanContext().SpanID().String())
+	tele.Tags.Operation().SetName(sp.Name())
+
+	exp.track(&tele)
+}
+
+// track counts the telemetry by its type and dispatches it to the application
+// insights telemetry client.
+func (exp *AppInsightsExporter) track(tele appinsights.Telemetry) {
+	switch tele.(type) {
+	case *appinsights.EventTelemetry:
+		exp.counters.eventsTracked.Add(1)
+	case *appinsights.RequestTelemetry:
+		exp.counters.requestsTracked.Add(1)
+	case *appinsights.RemoteDependencyTelemetry:
+		exp.counters.dependenciesTracked.Add(1)
+	case *appinsights.ExceptionTelemetry, *exceptionTelemetry:
+		exp.counters.exceptionsTracked.Add(1)
+	case *appinsights.AvailabilityTelemetry:
+		exp.counters.availabilityTracked.Add(1)
+	case *pageViewTelemetry:
+		exp.counters.pageViewsTracked.Add(1)
+	}
+	if exp.live != nil {
+		exp.live.track(tele)
+	}
+	exp.client.Track(tele)
+}
+
+// operationId returns the operation id of the telemetry of the span context,
+// which is the trace id, or the root of the Request-Id of the legacy caller
+// that the trace id was derived from.
+func operationId(sc trace.SpanContext) string {
+	if _, root := legacyRequestId(sc); root != "" {
+		return root
+	}
+	return sc.TraceID().String()
+}
+
+// parentId returns the operation parent id of the span's telemetry, which is
+// the id of the parent span, local or remote, or the Request-Id of a legacy
+// caller that the remote parent was derived from. Root spans have no parent
+// id, unless the exporter links them to the operation id as in earlier
+// versions.
+func (exp *AppInsightsExporter) parentId(sp sdktrace.ReadOnlySpan) string {
+	if parent := sp.Parent(); parent.IsValid() {
+		if id, _ := legacyRequestId(parent); id != "" && parent.IsRemote() &&
+			parseRequestId(id).SpanID() == parent.SpanID() {
+			return id
+		}
+		return parent.SpanID().String()
+	}
+	if exp.legacyParentIds {
+		return operationId(sp.SpanContext())
+	}
+	return ""
+}
+
+// hasExceptionEvent checks if an exception was recorded on the span.
+func hasExceptionEvent(sp sdktrace.ReadOnlySpan) bool {
+	for _, e := range sp.Events() {
+		if e.Name == semconv.ExceptionEventName {
+			return true
+		}
+	}
+	return false
 }
 
 // process routes the span to different processing functions based on the
@@ -312,24 +641,63 @@ func (exp *AppInsightsExporter) process(sp sdktrace.ReadOnlySpan) {
 	rattr := sp.Resource().Attributes()
 	for _, e := range rattr {
 		props[string(e.Key)] = e.Value.AsString()
+		if e.Key == semconv.ServiceNameKey && exp.live != nil {
+			exp.live.setRole(e.Value.AsString())
+		}
 	}
 	attr := sp.Attributes()
 	for _, e := range attr {
 		props[string(e.Key)] = e.Value.AsString()
 	}
 
-	switch sp.SpanKind() {
-	case trace.SpanKindUnspecified:
-		exp.processInternal(sp, props)
-	case trace.SpanKindInternal:
-		exp.processInternal(sp, props)
-	case trace.SpanKindServer:
-		exp.processRequest(sp, success, props)
-	case trace.SpanKindClient:
-		exp.processDependency(sp, success, props)
-	case trace.SpanKindProducer:
-		exp.processDependency(sp, success, props)
-	case trace.SpanKindConsumer:
-		exp.processEvent(sp, success, props)
+	scope := sp.InstrumentationScope()
+	if exp.scopeNameKey != "" && scope.Name != "" {
+		props[exp.scopeNameKey] = scope.Name
+	}
+	if exp.scopeVersionKey != "" && scope.Version != "" {
+		props[exp.scopeVersionKey] = scope.Version
+	}
+
+	meas := map[string]float64{}
+	if n := sp.DroppedAttributes(); n > 0 {
+		meas[droppedAttributesKey] = float64(n)
+		exp.counters.droppedAttributes.Add(uint64(n))
+	}
+	if n := sp.DroppedEvents(); n > 0 {
+		meas[droppedEventsKey] = float64(n)
+		exp.counters.droppedEvents.Add(uint64(n))
+	}
+	if n := sp.DroppedLinks(); n > 0 {
+		meas[droppedLinksKey] = float64(n)
+		exp.counters.droppedLinks.Add(uint64(n))
+	}
+
+	switch {
+	case isAvailabilityTest(sp):
+		exp.processAvailability(sp, success, props, meas)
+	case exp.isPageView(sp):
+		exp.processPageView(sp, props, meas)
+	case sp.SpanKind() == trace.SpanKindUnspecified:
+		exp.processInternal(sp, props, meas)
+	case sp.SpanKind() == trace.SpanKindInternal:
+		exp.processInternal(sp, props, meas)
+	case sp.SpanKind() == trace.SpanKindServer:
+		exp.processRequest(sp, success, props, meas)
+	case sp.SpanKind() == trace.SpanKindClient:
+		exp.processDependency(sp, success, props, meas)
+	case sp.SpanKind() == trace.SpanKindProducer:
+		exp.processDependency(sp, success, props, meas)
+	case sp.SpanKind() == trace.SpanKindConsumer:
+		if isMessageReceive(props) {
+			exp.processDependency(sp, success, props, meas)
+		} else {
+			exp.processEvent(sp, success, props, meas)
+		}
+	}
+	exp.processExceptionEvents(sp)
+
+	if exp.statusExceptions && sp.Status().Code == codes.Error &&
+		!hasExceptionEvent(sp) {
+		exp.processStatusException(sp)
 	}
 }
@@ -108,6 +108,74 @@ func TestNewExporterFromConfig(t *testing.T) {
 	}
 }
 
+// TestNewExporterWithClient tests that an exporter is created with the
+// provided telemetry client, which receives the telemetry of spans
+func TestNewExporterWithClient(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Client appinsights.TelemetryClient
+		Opts   []Option
+		Error  error
+	}{
+		{
+			Name:   "New exporter",
+			Client: &mockTelemetryClient{},
+			Error:  nil,
+		},
+		{
+			Name:   "New exporter with options",
+			Client: &mockTelemetryClient{},
+			Opts:   []Option{WithServiceName("checkout")},
+			Error:  nil,
+		},
+		{
+			Name:   "New exporter with missing client",
+			Client: nil,
+			Error:  errors.New("telemetry client is nil"),
+		},
+		{
+			Name:   "New exporter with sampling",
+			Client: &mockTelemetryClient{},
+			Opts:   []Option{WithSamplingPercentage(50)},
+			Error:  errors.New("sampling is not supported with a custom client"),
+		},
+		{
+			Name:   "New exporter with invalid sampling",
+			Client: &mockTelemetryClient{},
+			Opts:   []Option{WithSamplingPercentage(101)},
+			Error:  errors.New("sampling is not supported with a custom client"),
+		},
+		{
+			Name:   "New exporter without sampling",
+			Client: &mockTelemetryClient{},
+			Opts:   []Option{WithSamplingPercentage(100)},
+			Error:  nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			exp, err := NewExporterWithClient(test.Client, nil, test.Opts...)
+
+			assert.Equal(t, test.Error, err)
+			if test.Error != nil {
+				assert.Nil(t, exp)
+				return
+			}
+			assert.NotNil(t, exp)
+			assert.Equal(t, test.Client, exp.client)
+
+			res, _ := resource.New(context.Background())
+			exp.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{
+				&mockSpan{name: "span", kind: trace.SpanKindInternal, res: res},
+			})
+			tcl := test.Client.(*mockTelemetryClient)
+			assert.Equal(t, 1, len(tcl.tels))
+			assert.NoError(t, exp.Shutdown(context.Background()))
+		})
+	}
+}
+
 // TestExportSpans tests that spans fed to the exporter are processed
 func TestExportSpans(t *testing.T) {
 	tests := []struct {
@@ -292,7 +360,7 @@ func TestProcessInternal(t *testing.T) {
 				semconv.ServiceNameKey.String("test"),
 			},
 			SpanAttribs: []attribute.KeyValue{},
-			TelParent:   "00112233445566778899aabbccddeeff",
+			TelParent:   "",
 			TelSource:   "test",
 			TelProps:    map[string]string{},
 		},
@@ -437,7 +505,7 @@ func TestProcessRequest(t *testing.T) {
 			},
 			SpanAttribs: []attribute.KeyValue{},
 			TelId:       "0000000000000001",
-			TelParent:   "00112233445566778899aabbccddeeff",
+			TelParent:   "",
 			TelSource:   "test",
 			TelUrl:      "users/1234",
 			TelResCode:  "200",
@@ -596,7 +664,7 @@ func TestProcessEvent(t *testing.T) {
 			},
 			SpanAttribs: []attribute.KeyValue{},
 			TelId:       "0000000000000001",
-			TelParent:   "00112233445566778899aabbccddeeff",
+			TelParent:   "",
 			TelSource:   "test",
 			TelUrl:      "service.messages.created",
 			TelResCode:  "200",
@@ -776,7 +844,7 @@ func TestProcessDependency(t *testing.T) {
 				attribute.String("type", "httpclient"),
 			},
 			TelId:     "0000000000000001",
-			TelParent: "00112233445566778899aabbccddeeff",
+			TelParent: "",
 			TelSource: "server",
 			TelTarget: "client",
 			TelType:   "httpclient",
@@ -842,3 +910,290 @@ func TestProcessDependency(t *testing.T) {
 		})
 	}
 }
+
+// TestProcessStatus tests that the status description of spans is recorded
+// on the telemetry, and that exceptions are created for failed spans
+func TestProcessStatus(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Options []Option
+		Kind    trace.SpanKind
+		Status  sdktrace.Status
+		Events  []sdktrace.Event
+
+		TelProps   map[string]string
+		TelMessage string
+	}{
+		{
+			Name:     "Request with description",
+			Options:  []Option{},
+			Kind:     trace.SpanKindServer,
+			Status:   sdktrace.Status{Code: codes.Error, Description: "not found"},
+			TelProps: map[string]string{"otel.status_description": "not found"},
+		},
+		{
+			Name:     "Event with description",
+			Options:  []Option{},
+			Kind:     trace.SpanKindConsumer,
+			Status:   sdktrace.Status{Code: codes.Error, Description: "bad message"},
+			TelProps: map[string]string{"otel.status_description": "bad message"},
+		},
+		{
+			Name:     "Dependency with description",
+			Options:  []Option{},
+			Kind:     trace.SpanKindClient,
+			Status:   sdktrace.Status{Code: codes.Error, Description: "timeout"},
+			TelProps: map[string]string{"otel.status_description": "timeout"},
+		},
+		{
+			Name:     "Dependency without description",
+			Options:  []Option{},
+			Kind:     trace.SpanKindClient,
+			Status:   sdktrace.Status{Code: codes.Ok},
+			TelProps: map[string]string{},
+		},
+		{
+			Name:       "Exception from description",
+			Options:    []Option{WithStatusExceptions()},
+			Kind:       trace.SpanKindServer,
+			Status:     sdktrace.Status{Code: codes.Error, Description: "not found"},
+			TelProps:   map[string]string{"otel.status_description": "not found"},
+			TelMessage: "not found",
+		},
+		{
+			Name:       "Exception without description",
+			Options:    []Option{WithStatusExceptions()},
+			Kind:       trace.SpanKindInternal,
+			Status:     sdktrace.Status{Code: codes.Error},
+			TelProps:   map[string]string{},
+			TelMessage: "Error",
+		},
+		{
+			Name:    "No status exception for recorded exception",
+			Options: []Option{WithStatusExceptions()},
+			Kind:    trace.SpanKindServer,
+			Status:  sdktrace.Status{Code: codes.Error, Description: "not found"},
+			Events: []sdktrace.Event{
+				{Name: semconv.ExceptionEventName},
+			},
+			TelProps: map[string]string{"otel.status_description": "not found"},
+		},
+		{
+			Name:     "No exception for successful span",
+			Options:  []Option{WithStatusExceptions()},
+			Kind:     trace.SpanKindServer,
+			Status:   sdktrace.Status{Code: codes.Ok},
+			TelProps: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil, test.Options...)
+			exp.client = tcl
+
+			res, _ := resource.New(
+				context.Background(),
+				resource.WithAttributes(semconv.ServiceNameKey.String("test")),
+			)
+
+			now := time.Now()
+			span := &mockSpan{
+				name:      "span",
+				kind:      test.Kind,
+				status:    test.Status,
+				startTime: now,
+				endTime:   now.Add(time.Minute),
+				traceId: [16]byte{
+					0x00, 0x11, 0x22, 0x33,
+					0x44, 0x55, 0x66, 0x77,
+					0x88, 0x99, 0xAA, 0xBB,
+					0xCC, 0xDD, 0xEE, 0xFF,
+				},
+				spanId: [8]byte{
+					0x00, 0x00, 0x00, 0x00,
+					0x00, 0x00, 0x00, 0x01,
+				},
+				res:    res,
+				attr:   []attribute.KeyValue{},
+				events: test.Events,
+			}
+
+			exp.process(span)
+
+			if test.TelMessage == "" {
+				assert.Equal(t, 1+len(test.Events), len(tcl.tels))
+				for _, tel := range tcl.tels[1:] {
+					assert.IsType(t, tel, (*exceptionTelemetry)(nil))
+				}
+			} else {
+				assert.Equal(t, 2, len(tcl.tels))
+				assert.IsType(t, tcl.tels[1], (*appinsights.ExceptionTelemetry)(nil))
+				tel := tcl.tels[1].(*appinsights.ExceptionTelemetry)
+
+				assert.Equal(t, test.TelMessage, tel.Error)
+				assert.Equal(t, span.endTime, tel.Time())
+				assert.Equal(t, "test", tel.ContextTags()["ai.cloud.role"])
+				assert.Equal(t, "0000000000000001", tel.ContextTags()["ai.operation.parentId"])
+				assert.Equal(t, "00112233445566778899aabbccddeeff", tel.ContextTags()["ai.operation.id"])
+			}
+
+			if test.Kind != trace.SpanKindInternal {
+				assert.Equal(t, test.TelProps, tcl.tels[0].GetProperties())
+			}
+		})
+	}
+}
+
+// TestProcessDropped tests that the number of attributes, events and links
+// dropped from spans is recorded on the telemetry and in the statistics
+func TestProcessDropped(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Kind       trace.SpanKind
+		Attributes int
+		Events     int
+		Links      int
+
+		TelMeas map[string]float64
+	}{
+		{
+			Name:    "Nothing dropped",
+			Kind:    trace.SpanKindInternal,
+			TelMeas: map[string]float64{},
+		},
+		{
+			Name:       "Dropped attributes",
+			Kind:       trace.SpanKindServer,
+			Attributes: 3,
+			TelMeas: map[string]float64{
+				"otel.dropped_attributes_count": 3,
+			},
+		},
+		{
+			Name:       "Dropped everything",
+			Kind:       trace.SpanKindClient,
+			Attributes: 1,
+			Events:     2,
+			Links:      4,
+			TelMeas: map[string]float64{
+				"otel.dropped_attributes_count": 1,
+				"otel.dropped_events_count":     2,
+				"otel.dropped_links_count":      4,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil)
+			exp.client = tcl
+
+			res, _ := resource.New(context.Background())
+			span := &mockSpan{
+				name:              "span",
+				kind:              test.Kind,
+				status:            sdktrace.Status{Code: codes.Ok},
+				res:               res,
+				attr:              []attribute.KeyValue{},
+				droppedAttributes: test.Attributes,
+				droppedEvents:     test.Events,
+				droppedLinks:      test.Links,
+			}
+
+			exp.process(span)
+			exp.process(span)
+
+			assert.Equal(t, 2, len(tcl.tels))
+			assert.Equal(t, test.TelMeas, tcl.tels[0].GetMeasurements())
+
+			stats := exp.Stats()
+			assert.Equal(t, uint64(2*test.Attributes), stats.DroppedAttributes)
+			assert.Equal(t, uint64(2*test.Events), stats.DroppedEvents)
+			assert.Equal(t, uint64(2*test.Links), stats.DroppedLinks)
+		})
+	}
+}
+
+// TestProcessParent tests that the operation parent id links spans to local
+// and remote parents, and that root spans have no parent unless legacy
+// parent ids are enabled
+func TestProcessParent(t *testing.T) {
+	tests := []struct {
+		Name     string
+		ParentId [8]byte
+		Remote   bool
+		Opts     []Option
+
+		TelParent string
+	}{
+		{
+			Name:      "Local parent",
+			ParentId:  [8]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF},
+			TelParent: "0123456789abcdef",
+		},
+		{
+			Name:      "Remote parent",
+			ParentId:  [8]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF},
+			Remote:    true,
+			TelParent: "0123456789abcdef",
+		},
+		{
+			Name:      "Root span",
+			TelParent: "",
+		},
+		{
+			Name:      "Root span with legacy parent ids",
+			Opts:      []Option{WithLegacyParentIds()},
+			TelParent: "00112233445566778899aabbccddeeff",
+		},
+		{
+			Name:      "Remote parent with legacy parent ids",
+			ParentId:  [8]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF},
+			Remote:    true,
+			Opts:      []Option{WithLegacyParentIds()},
+			TelParent: "0123456789abcdef",
+		},
+	}
+
+	kinds := []trace.SpanKind{
+		trace.SpanKindInternal,
+		trace.SpanKindServer,
+		trace.SpanKindClient,
+		trace.SpanKindConsumer,
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil, test.Opts...)
+			exp.client = tcl
+
+			res, _ := resource.New(context.Background())
+			for _, kind := range kinds {
+				exp.process(&mockSpan{
+					name:   "span",
+					kind:   kind,
+					status: sdktrace.Status{Code: codes.Ok},
+					traceId: [16]byte{
+						0x00, 0x11, 0x22, 0x33,
+						0x44, 0x55, 0x66, 0x77,
+						0x88, 0x99, 0xAA, 0xBB,
+						0xCC, 0xDD, 0xEE, 0xFF,
+					},
+					parentId: test.ParentId,
+					remote:   test.Remote,
+					spanId:   [8]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+					res:      res,
+				})
+			}
+
+			assert.Equal(t, len(kinds), len(tcl.tels))
+			for _, tel := range tcl.tels {
+				assert.Equal(t, test.TelParent, tel.ContextTags()["ai.operation.parentId"])
+			}
+		})
+	}
+}
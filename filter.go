@@ -0,0 +1,112 @@
+package apex
+
+import (
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// Filter is a rule that describes spans which should not be exported, or
+// which should be exported as page views. A span matches the filter if it
+// satisfies every criteria that is set on it, and within each criteria it is
+// enough for one of the values to match. A filter without any criteria does
+// not match any span.
+//
+// Name, scope and attribute value patterns are globs, where '*' matches any
+// sequence of characters and '?' matches a single character.
+type Filter struct {
+	// Names is a list of patterns matched against the span's name.
+	Names []string
+	// Kinds is a list of span kinds the span's kind is compared against.
+	Kinds []trace.SpanKind
+	// Scopes is a list of patterns matched against the name of the
+	// instrumentation scope that created the span.
+	Scopes []string
+	// Attributes is a list of predicates on the span's attributes.
+	Attributes []AttributeFilter
+}
+
+// AttributeFilter is a predicate that matches spans having an attribute with
+// the given key and a value that matches the pattern.
+type AttributeFilter struct {
+	Key   string
+	Value string
+}
+
+// match checks if the span satisfies all the criteria of the filter.
+func (f *Filter) match(sp sdktrace.ReadOnlySpan) bool {
+	if len(f.Names) == 0 && len(f.Kinds) == 0 &&
+		len(f.Scopes) == 0 && len(f.Attributes) == 0 {
+		return false
+	}
+
+	if len(f.Names) > 0 && !matchAny(f.Names, sp.Name()) {
+		return false
+	}
+	if len(f.Kinds) > 0 {
+		found := false
+		for _, k := range f.Kinds {
+			if k == sp.SpanKind() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Scopes) > 0 && !matchAny(f.Scopes, sp.InstrumentationScope().Name) {
+		return false
+	}
+	if len(f.Attributes) > 0 {
+		found := false
+		attr := sp.Attributes()
+		for _, af := range f.Attributes {
+			for _, e := range attr {
+				if string(e.Key) == af.Key && matchGlob(af.Value, e.Value.Emit()) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny checks if the string matches any of the glob patterns.
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if matchGlob(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob checks if the string matches the glob pattern, where '*' matches
+// any sequence of characters and '?' matches exactly one character.
+func matchGlob(pattern, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+		case star >= 0:
+			next++
+			p, i = star+1, next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
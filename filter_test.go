@@ -0,0 +1,191 @@
+package apex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestMatchGlob tests that glob patterns are matched accurately
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Pattern string
+		Input   string
+		Match   bool
+	}{
+		{Name: "Exact match", Pattern: "/healthz", Input: "/healthz", Match: true},
+		{Name: "Exact mismatch", Pattern: "/healthz", Input: "/health", Match: false},
+		{Name: "Empty pattern", Pattern: "", Input: "", Match: true},
+		{Name: "Star matches empty", Pattern: "*", Input: "", Match: true},
+		{Name: "Star matches slashes", Pattern: "GET /*", Input: "GET /a/b/c", Match: true},
+		{Name: "Star in middle", Pattern: "GET /*/ready", Input: "GET /v1/ready", Match: true},
+		{Name: "Star in middle mismatch", Pattern: "GET /*/ready", Input: "GET /v1/live", Match: false},
+		{Name: "Multiple stars", Pattern: "*health*", Input: "GET /healthz", Match: true},
+		{Name: "Question mark", Pattern: "/v?/users", Input: "/v2/users", Match: true},
+		{Name: "Question mark needs char", Pattern: "/v?/users", Input: "//users", Match: false},
+		{Name: "Trailing characters", Pattern: "/ready", Input: "/readyz", Match: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Match, matchGlob(test.Pattern, test.Input))
+		})
+	}
+}
+
+// TestFilterMatch tests that filters match spans on all their criteria
+func TestFilterMatch(t *testing.T) {
+	span := &mockSpan{
+		name:  "GET /healthz",
+		kind:  trace.SpanKindServer,
+		scope: instrumentation.Scope{Name: "go.opentelemetry.io/contrib/otelhttp"},
+		attr: []attribute.KeyValue{
+			attribute.String("http.target", "/healthz"),
+			attribute.Int("http.status_code", 200),
+		},
+	}
+
+	tests := []struct {
+		Name   string
+		Filter Filter
+		Match  bool
+	}{
+		{
+			Name:   "Empty filter",
+			Filter: Filter{},
+			Match:  false,
+		},
+		{
+			Name:   "Name glob",
+			Filter: Filter{Names: []string{"*/healthz"}},
+			Match:  true,
+		},
+		{
+			Name:   "Name mismatch",
+			Filter: Filter{Names: []string{"GET /readyz"}},
+			Match:  false,
+		},
+		{
+			Name:   "Any of the names",
+			Filter: Filter{Names: []string{"GET /readyz", "GET /healthz"}},
+			Match:  true,
+		},
+		{
+			Name:   "Span kind",
+			Filter: Filter{Kinds: []trace.SpanKind{trace.SpanKindServer}},
+			Match:  true,
+		},
+		{
+			Name:   "Span kind mismatch",
+			Filter: Filter{Kinds: []trace.SpanKind{trace.SpanKindClient}},
+			Match:  false,
+		},
+		{
+			Name:   "Scope glob",
+			Filter: Filter{Scopes: []string{"*otelhttp"}},
+			Match:  true,
+		},
+		{
+			Name:   "Scope mismatch",
+			Filter: Filter{Scopes: []string{"*otelgrpc"}},
+			Match:  false,
+		},
+		{
+			Name: "String attribute",
+			Filter: Filter{Attributes: []AttributeFilter{
+				{Key: "http.target", Value: "/healthz"},
+			}},
+			Match: true,
+		},
+		{
+			Name: "Numeric attribute",
+			Filter: Filter{Attributes: []AttributeFilter{
+				{Key: "http.status_code", Value: "2??"},
+			}},
+			Match: true,
+		},
+		{
+			Name: "Attribute value mismatch",
+			Filter: Filter{Attributes: []AttributeFilter{
+				{Key: "http.target", Value: "/readyz"},
+			}},
+			Match: false,
+		},
+		{
+			Name: "Missing attribute",
+			Filter: Filter{Attributes: []AttributeFilter{
+				{Key: "http.route", Value: "*"},
+			}},
+			Match: false,
+		},
+		{
+			Name: "All criteria match",
+			Filter: Filter{
+				Names: []string{"GET *"},
+				Kinds: []trace.SpanKind{trace.SpanKindServer},
+				Attributes: []AttributeFilter{
+					{Key: "http.target", Value: "/healthz"},
+				},
+			},
+			Match: true,
+		},
+		{
+			Name: "One criteria mismatch",
+			Filter: Filter{
+				Names: []string{"GET *"},
+				Kinds: []trace.SpanKind{trace.SpanKindClient},
+			},
+			Match: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Match, test.Filter.match(span))
+		})
+	}
+}
+
+// TestExportSpansFiltered tests that spans matching filters are not exported
+// and are counted in the exporter's statistics
+func TestExportSpansFiltered(t *testing.T) {
+	tcl := &mockTelemetryClient{}
+	exp, _ := NewExporter("", nil, WithFilters(
+		Filter{Names: []string{"GET /healthz"}},
+		Filter{Attributes: []AttributeFilter{
+			{Key: "http.target", Value: "/readyz"},
+		}},
+	))
+	exp.client = tcl
+
+	res, _ := resource.New(context.Background())
+	newSpan := func(name string, attr ...attribute.KeyValue) *mockSpan {
+		return &mockSpan{
+			name:   name,
+			kind:   trace.SpanKindServer,
+			status: sdktrace.Status{Code: codes.Ok},
+			res:    res,
+			attr:   attr,
+		}
+	}
+
+	spans := []sdktrace.ReadOnlySpan{
+		newSpan("GET /healthz"),
+		newSpan("GET /probe", attribute.String("http.target", "/readyz")),
+		newSpan("GET /users", attribute.String("http.target", "/users")),
+	}
+
+	err := exp.ExportSpans(context.Background(), spans)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tcl.tels))
+	assert.Equal(t, uint64(2), exp.Stats().SpansFiltered)
+}
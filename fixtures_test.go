@@ -1,10 +1,16 @@
 package apex
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"time"
 
 	"github.com/microsoft/ApplicationInsights-Go/appinsights"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	trace "go.opentelemetry.io/otel/trace"
@@ -50,10 +56,17 @@ type mockSpan struct {
 	endTime   time.Time
 	traceId   [16]byte
 	parentId  [8]byte
+	remote    bool
 	spanId    [8]byte
 
-	res  *resource.Resource
-	attr []attribute.KeyValue
+	res    *resource.Resource
+	attr   []attribute.KeyValue
+	scope  instrumentation.Scope
+	events []sdktrace.Event
+
+	droppedAttributes int
+	droppedEvents     int
+	droppedLinks      int
 }
 
 func (s *mockSpan) Name() string {
@@ -80,6 +93,7 @@ func (s *mockSpan) Parent() trace.SpanContext {
 	return trace.NewSpanContext(trace.SpanContextConfig{
 		TraceID: s.traceId,
 		SpanID:  s.parentId,
+		Remote:  s.remote,
 	})
 }
 
@@ -97,3 +111,66 @@ func (s *mockSpan) Resource() *resource.Resource {
 func (s *mockSpan) Attributes() []attribute.KeyValue {
 	return s.attr
 }
+
+func (s *mockSpan) InstrumentationScope() instrumentation.Scope {
+	return s.scope
+}
+
+func (s *mockSpan) Events() []sdktrace.Event {
+	return s.events
+}
+
+func (s *mockSpan) DroppedAttributes() int {
+	return s.droppedAttributes
+}
+
+func (s *mockSpan) DroppedEvents() int {
+	return s.droppedEvents
+}
+
+func (s *mockSpan) DroppedLinks() int {
+	return s.droppedLinks
+}
+
+type mockIngestion struct {
+	*httptest.Server
+	mtx      sync.Mutex
+	requests []*http.Request
+	batches  [][]map[string]interface{}
+	respond  func(w http.ResponseWriter, n int, items []map[string]interface{})
+}
+
+func newMockIngestion(
+	respond func(w http.ResponseWriter, n int, items []map[string]interface{}),
+) *mockIngestion {
+	mi := &mockIngestion{respond: respond}
+	mi.Server = httptest.NewServer(http.HandlerFunc(mi.handle))
+	return mi
+}
+
+func (mi *mockIngestion) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	lines, _ := decompress(body)
+	items := []map[string]interface{}{}
+	for _, l := range lines {
+		item := map[string]interface{}{}
+		json.Unmarshal(l, &item)
+		items = append(items, item)
+	}
+
+	mi.mtx.Lock()
+	mi.requests = append(mi.requests, r)
+	mi.batches = append(mi.batches, items)
+	n := len(mi.batches)
+	mi.mtx.Unlock()
+
+	if mi.respond != nil {
+		mi.respond(w, n, items)
+	}
+}
+
+func (mi *mockIngestion) received() [][]map[string]interface{} {
+	mi.mtx.Lock()
+	defer mi.mtx.Unlock()
+	return append([][]map[string]interface{}{}, mi.batches...)
+}
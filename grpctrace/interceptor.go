@@ -0,0 +1,429 @@
+// Package grpctrace instruments gRPC clients and servers with spans in the
+// shape that the apex exporter maps to Application Insights requests and
+// dependencies.
+package grpctrace
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// scopeName is the name of the instrumentation scope of the spans created
+// by the package.
+const scopeName = "github.com/Soreing/apex/grpctrace"
+
+// responseCodeKey is the attribute that the exporter maps to the response
+// code of requests and the result code of dependencies.
+const responseCodeKey = attribute.Key("responseCode")
+
+type config struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+// Option configures the instrumentation.
+type Option func(*config)
+
+// newConfig creates the configuration from the options, using the global
+// tracer provider and propagator by default.
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		provider:   otel.GetTracerProvider(),
+		propagator: otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithTracerProvider sets the tracer provider that creates the spans.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(cfg *config) {
+		cfg.provider = provider
+	}
+}
+
+// WithPropagators sets the propagator of the trace context in metadata.
+func WithPropagators(propagator propagation.TextMapPropagator) Option {
+	return func(cfg *config) {
+		cfg.propagator = propagator
+	}
+}
+
+// UnaryClientInterceptor creates client spans for unary calls, which the
+// exporter maps to GRPC dependencies with the called host and port as their
+// target and the gRPC status code as their result code. Calls that do not
+// end with an OK status fail the span.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	cfg := newConfig(opts)
+	tracer := cfg.provider.Tracer(scopeName)
+
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		callOpts ...grpc.CallOption,
+	) error {
+		ctx, span := startClient(ctx, tracer, cfg.propagator, method, cc.Target())
+		defer span.End()
+
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		setStatus(span, err, clientFailure)
+		return err
+	}
+}
+
+// StreamClientInterceptor creates client spans for streaming calls, which
+// end when the stream is finished or fails.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	cfg := newConfig(opts)
+	tracer := cfg.provider.Tracer(scopeName)
+
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		callOpts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, span := startClient(ctx, tracer, cfg.propagator, method, cc.Target())
+
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			setStatus(span, err, clientFailure)
+			span.End()
+			return cs, err
+		}
+
+		stream := &clientStream{
+			ClientStream:  cs,
+			serverStreams: desc.ServerStreams,
+			span:          span,
+			done:          make(chan struct{}),
+		}
+
+		// The stream's context is done when the call ends, even if the
+		// caller never drains the stream, so the goroutine does not outlive
+		// the call. Calls that end otherwise finish the span in RecvMsg.
+		streamCtx := cs.Context()
+		go func() {
+			select {
+			case <-streamCtx.Done():
+				if err := ctx.Err(); err != nil {
+					stream.finish(status.FromContextError(err).Err())
+				}
+			case <-stream.done:
+			}
+		}()
+		return stream, nil
+	}
+}
+
+// UnaryServerInterceptor creates server spans for unary calls, which the
+// exporter maps to requests with the gRPC status code as their response
+// code. Following the semantic conventions, only status codes that indicate
+// server errors fail the span.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	cfg := newConfig(opts)
+	tracer := cfg.provider.Tracer(scopeName)
+
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, span := startServer(ctx, tracer, cfg.propagator, info.FullMethod)
+		defer span.End()
+
+		res, err := handler(ctx, req)
+		setStatus(span, err, serverFailure)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor creates server spans for streaming calls, which
+// end when the handler returns.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	cfg := newConfig(opts)
+	tracer := cfg.provider.Tracer(scopeName)
+
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := startServer(ss.Context(), tracer, cfg.propagator, info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		setStatus(span, err, serverFailure)
+		return err
+	}
+}
+
+// startClient starts a client span for the call and propagates its context
+// in the outgoing metadata.
+func startClient(
+	ctx context.Context,
+	tracer trace.Tracer,
+	propagator propagation.TextMapPropagator,
+	method string,
+	target string,
+) (context.Context, trace.Span) {
+	attrs := methodAttributes(method)
+	attrs = append(attrs, peerAttributes(target)...)
+
+	ctx, span := tracer.Start(
+		ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// startServer starts a server span for the call, continuing the trace in
+// the incoming metadata.
+func startServer(
+	ctx context.Context,
+	tracer trace.Tracer,
+	propagator propagation.TextMapPropagator,
+	method string,
+) (context.Context, trace.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = propagator.Extract(ctx, metadataCarrier(md))
+	}
+
+	attrs := methodAttributes(method)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			attrs = append(attrs, semconv.NetPeerIPKey.String(host))
+		}
+	}
+
+	return tracer.Start(
+		ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// methodAttributes creates the rpc attributes of the full method name.
+func methodAttributes(method string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.RPCSystemKey.String("grpc")}
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if ok {
+		attrs = append(attrs,
+			semconv.RPCServiceKey.String(service),
+			semconv.RPCMethodKey.String(name),
+		)
+	}
+	return attrs
+}
+
+// peerAttributes creates the peer attributes of the target of a client
+// connection.
+func peerAttributes(target string) []attribute.KeyValue {
+	addr, unix := targetAddress(target)
+	if addr == "" {
+		return nil
+	}
+	if unix {
+		return []attribute.KeyValue{
+			semconv.NetTransportUnix,
+			semconv.NetPeerNameKey.String(addr),
+		}
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []attribute.KeyValue{semconv.NetPeerNameKey.String(addr)}
+	}
+
+	attrs := []attribute.KeyValue{semconv.NetPeerNameKey.String(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.NetPeerPortKey.Int(p))
+	}
+	return attrs
+}
+
+// targetAddress extracts the address from the target of a client
+// connection, following the gRPC name syntax. Targets may have a resolver
+// scheme with an authority, such as "dns://8.8.8.8/host:port", or name a
+// unix domain socket, such as "unix:///path" or "unix:path", in which case
+// the address is the path of the socket. Targets without a known scheme are
+// addresses themselves, such as "host:port".
+func targetAddress(target string) (addr string, unix bool) {
+	scheme, rest, ok := strings.Cut(target, ":")
+	if !ok || !isScheme(scheme) {
+		return target, false
+	}
+
+	switch strings.ToLower(scheme) {
+	case "unix":
+		// "unix:///path" has an empty authority and an absolute path.
+		return strings.TrimPrefix(rest, "//"), true
+	case "unix-abstract":
+		return rest, true
+	}
+	if strings.HasPrefix(rest, "//") {
+		_, endpoint, _ := strings.Cut(rest[2:], "/")
+		return endpoint, false
+	}
+	switch strings.ToLower(scheme) {
+	case "dns", "passthrough":
+		return rest, false
+	}
+	return target, false
+}
+
+// isScheme checks if the string is a valid URI scheme.
+func isScheme(s string) bool {
+	for i, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// clientFailure checks if the status code fails a client span, which is
+// any code but OK.
+func clientFailure(code grpccodes.Code) bool {
+	return code != grpccodes.OK
+}
+
+// serverFailure checks if the status code fails a server span, which are the
+// codes that indicate server errors.
+func serverFailure(code grpccodes.Code) bool {
+	switch code {
+	case grpccodes.Unknown,
+		grpccodes.DeadlineExceeded,
+		grpccodes.Unimplemented,
+		grpccodes.Internal,
+		grpccodes.Unavailable,
+		grpccodes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// setStatus records the gRPC status code of the call's error on the span,
+// and sets the status of the span, which the exporter requires to be ok for
+// successful calls.
+func setStatus(span trace.Span, err error, failure func(grpccodes.Code) bool) {
+	st, _ := status.FromError(err)
+	code := st.Code()
+	span.SetAttributes(
+		semconv.RPCGRPCStatusCodeKey.Int(int(code)),
+		responseCodeKey.String(strconv.Itoa(int(code))),
+	)
+	if failure(code) {
+		span.SetStatus(codes.Error, st.Message())
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+}
+
+// clientStream is a client stream that ends the span of the call when the
+// stream finishes.
+type clientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	span          trace.Span
+	once          sync.Once
+	done          chan struct{}
+}
+
+// RecvMsg receives a message, and ends the span when the stream finishes.
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.serverStreams:
+		s.finish(nil)
+	}
+	return err
+}
+
+// finish ends the span with the status of the error the first time it is
+// called.
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		setStatus(s.span, err, clientFailure)
+		s.span.End()
+		close(s.done)
+	})
+}
+
+// serverStream is a server stream with the context of the call's span.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the call's span.
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// metadataCarrier adapts gRPC metadata to a text map carrier.
+type metadataCarrier metadata.MD
+
+// Get returns the first value of the key.
+func (c metadataCarrier) Get(key string) string {
+	vals := metadata.MD(c).Get(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// Set sets the value of the key.
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// Keys returns the keys of the metadata.
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
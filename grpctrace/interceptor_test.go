@@ -0,0 +1,287 @@
+package grpctrace
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// attributes converts the span's attributes to a map of emitted values.
+func attributes(span sdktrace.ReadOnlySpan) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range span.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attrs
+}
+
+// newTestConn starts an instrumented health server and connects to it with
+// an instrumented client. Spans of both sides are recorded.
+func newTestConn(t *testing.T) (*grpc.ClientConn, *tracetest.SpanRecorder) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	opts := []Option{
+		WithTracerProvider(tp),
+		WithPropagators(propagation.TraceContext{}),
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(opts...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(opts...)),
+	)
+	hs := health.NewServer()
+	hs.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial(
+		"passthrough:///orders.internal:50051",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(opts...)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(opts...)),
+	)
+	assert.Nil(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+	return conn, sr
+}
+
+// spanOfKind finds the recorded span of the kind.
+func spanOfKind(spans []sdktrace.ReadOnlySpan, kind trace.SpanKind) sdktrace.ReadOnlySpan {
+	for _, sp := range spans {
+		if sp.SpanKind() == kind {
+			return sp
+		}
+	}
+	return nil
+}
+
+// TestUnaryInterceptors tests that unary calls create client and server spans
+// with the attributes mapped by the exporter and the right success semantics
+func TestUnaryInterceptors(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Service      string
+		ResponseCode string
+		ClientStatus codes.Code
+		ServerStatus codes.Code
+	}{
+		{
+			Name:         "Successful call",
+			Service:      "orders",
+			ResponseCode: "0",
+			ClientStatus: codes.Ok,
+			ServerStatus: codes.Ok,
+		},
+		{
+			Name:         "Client error",
+			Service:      "unknown",
+			ResponseCode: "5",
+			ClientStatus: codes.Error,
+			ServerStatus: codes.Ok,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			conn, sr := newTestConn(t)
+			client := healthpb.NewHealthClient(conn)
+			client.Check(context.Background(), &healthpb.HealthCheckRequest{
+				Service: test.Service,
+			})
+
+			spans := sr.Ended()
+			assert.Equal(t, 2, len(spans))
+			cs := spanOfKind(spans, trace.SpanKindClient)
+			ss := spanOfKind(spans, trace.SpanKindServer)
+
+			assert.Equal(t, "grpc.health.v1.Health/Check", cs.Name())
+			assert.Equal(t, test.ClientStatus, cs.Status().Code)
+			assert.Equal(t, test.ServerStatus, ss.Status().Code)
+			assert.Equal(t, cs.SpanContext().SpanID(), ss.Parent().SpanID())
+			assert.Equal(t, cs.SpanContext().TraceID(), ss.SpanContext().TraceID())
+
+			attrs := attributes(cs)
+			assert.Equal(t, "grpc", attrs["rpc.system"])
+			assert.Equal(t, "grpc.health.v1.Health", attrs["rpc.service"])
+			assert.Equal(t, "Check", attrs["rpc.method"])
+			assert.Equal(t, "orders.internal", attrs["net.peer.name"])
+			assert.Equal(t, "50051", attrs["net.peer.port"])
+			assert.Equal(t, test.ResponseCode, attrs["responseCode"])
+			assert.Equal(t, test.ResponseCode, attrs["rpc.grpc.status_code"])
+			assert.Equal(t, test.ResponseCode, attributes(ss)["responseCode"])
+		})
+	}
+}
+
+// TestStreamInterceptors tests that streaming calls create spans that end
+// when the stream finishes
+func TestStreamInterceptors(t *testing.T) {
+	conn, sr := newTestConn(t)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+	assert.Nil(t, err)
+	res, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+	assert.Equal(t, 0, len(sr.Ended()))
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return len(sr.Ended()) == 2
+	}, time.Second, time.Millisecond)
+
+	spans := sr.Ended()
+	cs := spanOfKind(spans, trace.SpanKindClient)
+	ss := spanOfKind(spans, trace.SpanKindServer)
+	assert.Equal(t, "grpc.health.v1.Health/Watch", cs.Name())
+	assert.Equal(t, codes.Error, cs.Status().Code)
+	assert.Equal(t, "1", attributes(cs)["responseCode"])
+	assert.Equal(t, "1", attributes(ss)["responseCode"])
+	assert.Equal(t, codes.Ok, ss.Status().Code)
+}
+
+// TestStreamNotDrained tests that streams that the caller never drains do
+// not leave goroutines behind once the call ends
+func TestStreamNotDrained(t *testing.T) {
+	conn, _ := newTestConn(t)
+	client := healthpb.NewHealthClient(conn)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		stream, err := client.Watch(
+			context.Background(),
+			&healthpb.HealthCheckRequest{Service: "orders"},
+		)
+		assert.Nil(t, err)
+		_, err = stream.Recv()
+		assert.Nil(t, err)
+	}
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= before
+	}, time.Second, time.Millisecond)
+}
+
+// TestPeerAttributes tests that peer attributes are created from the
+// addresses of targets of client connections
+func TestPeerAttributes(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Target string
+		Addr   string
+		Unix   bool
+		Attrs  map[string]string
+	}{
+		{
+			Name:   "Host and port",
+			Target: "orders:50051",
+			Addr:   "orders:50051",
+			Attrs:  map[string]string{"net.peer.name": "orders", "net.peer.port": "50051"},
+		},
+		{
+			Name:   "IPv6 address",
+			Target: "[::1]:50051",
+			Addr:   "[::1]:50051",
+			Attrs:  map[string]string{"net.peer.name": "::1", "net.peer.port": "50051"},
+		},
+		{
+			Name:   "DNS scheme",
+			Target: "dns:///orders:50051",
+			Addr:   "orders:50051",
+			Attrs:  map[string]string{"net.peer.name": "orders", "net.peer.port": "50051"},
+		},
+		{
+			Name:   "DNS scheme with authority",
+			Target: "dns://8.8.8.8/orders:50051",
+			Addr:   "orders:50051",
+			Attrs:  map[string]string{"net.peer.name": "orders", "net.peer.port": "50051"},
+		},
+		{
+			Name:   "DNS scheme without authority",
+			Target: "dns:orders:50051",
+			Addr:   "orders:50051",
+			Attrs:  map[string]string{"net.peer.name": "orders", "net.peer.port": "50051"},
+		},
+		{
+			Name:   "Passthrough scheme",
+			Target: "passthrough:///orders",
+			Addr:   "orders",
+			Attrs:  map[string]string{"net.peer.name": "orders"},
+		},
+		{
+			Name:   "Custom scheme",
+			Target: "xds:///orders.internal:50051",
+			Addr:   "orders.internal:50051",
+			Attrs:  map[string]string{"net.peer.name": "orders.internal", "net.peer.port": "50051"},
+		},
+		{
+			Name:   "Unix absolute path",
+			Target: "unix:///var/run/orders.sock",
+			Addr:   "/var/run/orders.sock",
+			Unix:   true,
+			Attrs:  map[string]string{"net.peer.name": "/var/run/orders.sock", "net.transport": "unix"},
+		},
+		{
+			Name:   "Unix relative path",
+			Target: "unix:run/orders.sock",
+			Addr:   "run/orders.sock",
+			Unix:   true,
+			Attrs:  map[string]string{"net.peer.name": "run/orders.sock", "net.transport": "unix"},
+		},
+		{
+			Name:   "Unix abstract socket",
+			Target: "unix-abstract:orders",
+			Addr:   "orders",
+			Unix:   true,
+			Attrs:  map[string]string{"net.peer.name": "orders", "net.transport": "unix"},
+		},
+		{
+			Name:   "Empty target",
+			Target: "",
+			Addr:   "",
+			Attrs:  map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			addr, unix := targetAddress(test.Target)
+			assert.Equal(t, test.Addr, addr)
+			assert.Equal(t, test.Unix, unix)
+
+			attrs := map[string]string{}
+			for _, kv := range peerAttributes(test.Target) {
+				attrs[string(kv.Key)] = kv.Value.Emit()
+			}
+			assert.Equal(t, test.Attrs, attrs)
+		})
+	}
+}
@@ -0,0 +1,346 @@
+// Package httptrace instruments net/http servers and clients with spans in
+// the shape that the apex exporter maps to Application Insights requests and
+// dependencies.
+package httptrace
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scopeName is the name of the instrumentation scope of the spans created
+// by the package.
+const scopeName = "github.com/Soreing/apex/httptrace"
+
+// Attributes that the exporter maps to fields of Application Insights
+// telemetry.
+const (
+	urlKey          = attribute.Key("url")
+	responseCodeKey = attribute.Key("responseCode")
+)
+
+type config struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+	route      func(r *http.Request) string
+	headers    []string
+}
+
+// defaultRequestHeaders are the request headers captured by the handler,
+// which identify the calling application and availability tests.
+var defaultRequestHeaders = []string{
+	"Request-Context",
+	"SyntheticTest-RunId",
+	"SyntheticTest-Location",
+}
+
+// Option configures the instrumentation.
+type Option func(*config)
+
+// newConfig creates the configuration from the options, using the global
+// tracer provider and propagator by default.
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		provider:   otel.GetTracerProvider(),
+		propagator: otel.GetTextMapPropagator(),
+		headers:    defaultRequestHeaders,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithTracerProvider sets the tracer provider that creates the spans.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(cfg *config) {
+		cfg.provider = provider
+	}
+}
+
+// WithPropagators sets the propagator of the trace context in requests.
+func WithPropagators(propagator propagation.TextMapPropagator) Option {
+	return func(cfg *config) {
+		cfg.propagator = propagator
+	}
+}
+
+// WithRoute sets the route template of the handler, such as "/users/{id}",
+// which names the server spans along with the method.
+func WithRoute(route string) Option {
+	return func(cfg *config) {
+		cfg.route = func(r *http.Request) string { return route }
+	}
+}
+
+// WithRouteFunc sets a function that finds the route template of requests,
+// typically from the router, which names the server spans along with the
+// method.
+func WithRouteFunc(route func(r *http.Request) string) Option {
+	return func(cfg *config) {
+		cfg.route = route
+	}
+}
+
+// WithRequestHeaders adds request headers that the handler captures as
+// "http.request.header.*" attributes of the server spans, in addition to the
+// Request-Context and availability test headers, such as headers that the
+// exporter is configured to detect synthetic traffic with.
+func WithRequestHeaders(headers ...string) Option {
+	return func(cfg *config) {
+		cfg.headers = append(append([]string{}, cfg.headers...), headers...)
+	}
+}
+
+// handler is an http handler that traces requests to the next handler.
+type handler struct {
+	next       http.Handler
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	route      func(r *http.Request) string
+	headers    []string
+}
+
+// NewHandler wraps the handler with a middleware that creates a server span
+// for each request, named after the method and route of the request, or
+// only the method if the route is unknown, such as "HTTP GET", so that the
+// names of spans do not contain ids and other values from the path. The
+// span has the url and response code attributes that the exporter maps to
+// the request telemetry, and records panics of the handler as exceptions.
+//
+// Following the semantic conventions, responses with 5xx status codes and
+// panics fail the span, while 4xx status codes do not. Other spans have an
+// ok status, so that the exporter reports them as successful.
+func NewHandler(next http.Handler, opts ...Option) http.Handler {
+	cfg := newConfig(opts)
+	return &handler{
+		next:       next,
+		tracer:     cfg.provider.Tracer(scopeName),
+		propagator: cfg.propagator,
+		route:      cfg.route,
+		headers:    cfg.headers,
+	}
+}
+
+// ServeHTTP traces the request and serves it with the next handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := h.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	route := ""
+	if h.route != nil {
+		route = h.route(r)
+	}
+	name := "HTTP " + r.Method
+	if route != "" {
+		name = r.Method + " " + route
+	}
+
+	attrs := []attribute.KeyValue{
+		urlKey.String(requestURL(r)),
+		semconv.HTTPMethodKey.String(r.Method),
+		semconv.HTTPTargetKey.String(r.URL.RequestURI()),
+		semconv.HTTPSchemeKey.String(scheme(r)),
+		semconv.HTTPHostKey.String(r.Host),
+		semconv.HTTPFlavorKey.String(strings.TrimPrefix(r.Proto, "HTTP/")),
+	}
+	if route != "" {
+		attrs = append(attrs, semconv.HTTPRouteKey.String(route))
+	}
+	if ua := r.UserAgent(); ua != "" {
+		attrs = append(attrs, semconv.HTTPUserAgentKey.String(ua))
+	}
+	if ip := clientIP(r); ip != "" {
+		attrs = append(attrs, semconv.HTTPClientIPKey.String(ip))
+	}
+	for _, header := range h.headers {
+		if val := r.Header.Get(header); val != "" {
+			attrs = append(attrs, attribute.String(
+				"http.request.header."+strings.ToLower(header), val,
+			))
+		}
+	}
+
+	ctx, span := h.tracer.Start(
+		ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+
+	// The span is ended in the deferred function rather than deferred by
+	// itself, so that the sdk does not record the panic a second time.
+	rw := &responseWriter{ResponseWriter: w}
+	defer func() {
+		if rec := recover(); rec != nil {
+			span.RecordError(
+				fmt.Errorf("panic: %v", rec),
+				trace.WithStackTrace(true),
+			)
+			span.SetStatus(codes.Error, fmt.Sprint(rec))
+			if rw.status == 0 {
+				rw.status = http.StatusInternalServerError
+			}
+			setStatus(span, rw.status, true)
+			span.End()
+			panic(rec)
+		}
+		setStatus(span, rw.status, false)
+		span.End()
+	}()
+
+	h.next.ServeHTTP(rw.wrap(), r.WithContext(ctx))
+}
+
+// setStatus records the response status code on the span, and sets the
+// status of the span, which the exporter requires to be ok for successful
+// requests.
+func setStatus(span trace.Span, status int, panicked bool) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttributes(
+		responseCodeKey.String(strconv.Itoa(status)),
+		semconv.HTTPStatusCodeKey.Int(status),
+	)
+	if panicked {
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+}
+
+// requestURL reconstructs the absolute url of the request.
+func requestURL(r *http.Request) string {
+	return scheme(r) + "://" + r.Host + r.URL.RequestURI()
+}
+
+// scheme returns the scheme the request was received with.
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// clientIP returns the address of the client, preferring the first address
+// in the X-Forwarded-For header set by proxies.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		ip, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(ip)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// responseWriter records the status code written to the response.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and writes it to the response.
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the data to the response, with a 200 status code if none was
+// written before.
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush sends buffered data to the client if the response supports it.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped response writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// wrap returns the response writer with the optional interfaces of the
+// wrapped response writer, so that handlers can still hijack connections or
+// push resources when the response supports it.
+func (w *responseWriter) wrap() http.ResponseWriter {
+	_, hijack := w.ResponseWriter.(http.Hijacker)
+	_, push := w.ResponseWriter.(http.Pusher)
+	switch {
+	case hijack && push:
+		return hijackPushWriter{w}
+	case hijack:
+		return hijackWriter{w}
+	case push:
+		return pushWriter{w}
+	}
+	return w
+}
+
+// hijack takes over the connection of the response, which switches
+// protocols unless a status code was written before.
+func (w *responseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// push initiates an http/2 server push of the target.
+func (w *responseWriter) push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// hijackWriter is a response writer whose connection can be hijacked.
+type hijackWriter struct{ *responseWriter }
+
+// Hijack takes over the connection of the response.
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+// pushWriter is a response writer that supports http/2 server push.
+type pushWriter struct{ *responseWriter }
+
+// Push initiates an http/2 server push of the target.
+func (w pushWriter) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
+}
+
+// hijackPushWriter is a response writer whose connection can be hijacked
+// and that supports http/2 server push.
+type hijackPushWriter struct{ *responseWriter }
+
+// Hijack takes over the connection of the response.
+func (w hijackPushWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+// Push initiates an http/2 server push of the target.
+func (w hijackPushWriter) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
+}
@@ -0,0 +1,303 @@
+package httptrace
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTestProvider creates a tracer provider that records ended spans.
+func newTestProvider() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	sr := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)), sr
+}
+
+// attributes converts the span's attributes to a map of emitted values.
+func attributes(span sdktrace.ReadOnlySpan) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range span.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attrs
+}
+
+// TestHandler tests that server spans are created with the attributes mapped
+// by the exporter
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Opts    []Option
+		Target  string
+		Handler http.HandlerFunc
+
+		SpanName     string
+		ResponseCode string
+		Status       codes.Code
+		Attributes   map[string]string
+	}{
+		{
+			Name:   "Successful request",
+			Target: "/users/42?expand=true",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			},
+			SpanName:     "HTTP GET",
+			ResponseCode: "200",
+			Status:       codes.Ok,
+			Attributes: map[string]string{
+				"url":         "http://example.com/users/42?expand=true",
+				"http.method": "GET",
+				"http.target": "/users/42?expand=true",
+			},
+		},
+		{
+			Name:   "Route template",
+			Opts:   []Option{WithRoute("/users/{id}")},
+			Target: "/users/42",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			SpanName:     "GET /users/{id}",
+			ResponseCode: "404",
+			Status:       codes.Ok,
+			Attributes: map[string]string{
+				"http.route":       "/users/{id}",
+				"http.status_code": "404",
+			},
+		},
+		{
+			Name: "Route function",
+			Opts: []Option{WithRouteFunc(func(r *http.Request) string {
+				return "/orders/{id}"
+			})},
+			Target: "/orders/7",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			SpanName:     "GET /orders/{id}",
+			ResponseCode: "503",
+			Status:       codes.Error,
+		},
+		{
+			Name:         "No response written",
+			Target:       "/",
+			Handler:      func(w http.ResponseWriter, r *http.Request) {},
+			SpanName:     "HTTP GET",
+			ResponseCode: "200",
+			Status:       codes.Ok,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tp, sr := newTestProvider()
+			opts := append([]Option{WithTracerProvider(tp)}, test.Opts...)
+			h := NewHandler(test.Handler, opts...)
+
+			req := httptest.NewRequest(http.MethodGet, test.Target, nil)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := sr.Ended()
+			assert.Equal(t, 1, len(spans))
+			span := spans[0]
+			attrs := attributes(span)
+
+			assert.Equal(t, test.SpanName, span.Name())
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+			assert.Equal(t, test.ResponseCode, attrs["responseCode"])
+			assert.Equal(t, test.Status, span.Status().Code)
+			assert.Equal(t, scopeName, span.InstrumentationScope().Name)
+			for k, v := range test.Attributes {
+				assert.Equal(t, v, attrs[k], k)
+			}
+		})
+	}
+}
+
+// TestHandlerPanic tests that panics are recorded as exceptions on failed
+// spans and propagated to the server
+func TestHandlerPanic(t *testing.T) {
+	tp, sr := newTestProvider()
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), WithTracerProvider(tp))
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	assert.PanicsWithValue(t, "boom", func() {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	})
+
+	spans := sr.Ended()
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "boom", spans[0].Status().Description)
+	assert.Equal(t, "500", attributes(spans[0])["responseCode"])
+	assert.Equal(t, 1, len(spans[0].Events()))
+	assert.Equal(t, "exception", spans[0].Events()[0].Name)
+}
+
+// TestHandlerPropagation tests that server spans continue the trace of the
+// incoming request
+func TestHandlerPropagation(t *testing.T) {
+	tp, sr := newTestProvider()
+	h := NewHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.True(t, trace.SpanContextFromContext(r.Context()).IsValid())
+		}),
+		WithTracerProvider(tp),
+		WithPropagators(propagation.TraceContext{}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	span := sr.Ended()[0]
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext().TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", span.Parent().SpanID().String())
+	assert.True(t, span.Parent().IsRemote())
+	assert.Equal(t, "203.0.113.7", attributes(span)["http.client_ip"])
+}
+
+// TestHandlerHeaders tests that the Request-Context, availability test and
+// configured request headers are captured on server spans
+func TestHandlerHeaders(t *testing.T) {
+	tp, sr := newTestProvider()
+	h := NewHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		WithTracerProvider(tp),
+		WithRequestHeaders("X-Load-Test"),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Request-Context", "appId=cid-v1:caller-app")
+	req.Header.Set("SyntheticTest-RunId", "run-1")
+	req.Header.Set("X-Load-Test", "1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	attrs := attributes(sr.Ended()[0])
+	assert.Equal(t, "appId=cid-v1:caller-app", attrs["http.request.header.request-context"])
+	assert.Equal(t, "run-1", attrs["http.request.header.synthetictest-runid"])
+	assert.Equal(t, "1", attrs["http.request.header.x-load-test"])
+	assert.NotContains(t, attrs, "http.request.header.synthetictest-location")
+}
+
+// TestHandlerUpgrade tests that connections can be hijacked through the
+// middleware to switch protocols, such as for websockets
+func TestHandlerUpgrade(t *testing.T) {
+	tp, sr := newTestProvider()
+	h := NewHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hj, ok := w.(http.Hijacker)
+			if !assert.True(t, ok) {
+				return
+			}
+			conn, brw, err := hj.Hijack()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+
+			brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+				"Upgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+			brw.Flush()
+			line, _ := brw.ReadString('\n')
+			brw.WriteString(line)
+			brw.Flush()
+		}),
+		WithTracerProvider(tp),
+	)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	conn.Write([]byte("GET /echo HTTP/1.1\r\nHost: example.com\r\n" +
+		"Upgrade: echo\r\nConnection: Upgrade\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+	conn.Write([]byte("ping\n"))
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "ping\n", line)
+
+	assert.Eventually(t, func() bool {
+		return len(sr.Ended()) == 1
+	}, time.Second, time.Millisecond)
+	span := sr.Ended()[0]
+	assert.Equal(t, "101", attributes(span)["responseCode"])
+	assert.Equal(t, codes.Ok, span.Status().Code)
+}
+
+// pushRecorder is a response recorder that supports http/2 server push.
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (w *pushRecorder) Push(target string, opts *http.PushOptions) error {
+	w.pushed = append(w.pushed, target)
+	return nil
+}
+
+// TestHandlerInterfaces tests that the response writer of the middleware
+// only has the optional interfaces of the wrapped response writer
+func TestHandlerInterfaces(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Writer http.ResponseWriter
+		Hijack bool
+		Push   bool
+	}{
+		{
+			Name:   "Recorder",
+			Writer: httptest.NewRecorder(),
+		},
+		{
+			Name:   "Pusher",
+			Writer: &pushRecorder{ResponseRecorder: httptest.NewRecorder()},
+			Push:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tp, _ := newTestProvider()
+			h := NewHandler(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, hijack := w.(http.Hijacker)
+					assert.Equal(t, test.Hijack, hijack)
+					p, push := w.(http.Pusher)
+					assert.Equal(t, test.Push, push)
+					if push {
+						assert.NoError(t, p.Push("/app.js", nil))
+					}
+					_, flush := w.(http.Flusher)
+					assert.True(t, flush)
+				}),
+				WithTracerProvider(tp),
+			)
+			h.ServeHTTP(test.Writer, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if pr, ok := test.Writer.(*pushRecorder); ok {
+				assert.Equal(t, []string{"/app.js"}, pr.pushed)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestContextKey is the attribute of the captured Request-Context response
+// header, from which the exporter resolves the application id of the callee.
+const requestContextKey = attribute.Key("http.response.header.request-context")
+
+// redacted replaces the values of query parameters in the urls of requests.
+const redacted = "REDACTED"
+
+// transport is an http round tripper that traces requests sent by the base
+// round tripper.
+type transport struct {
+	base       http.RoundTripper
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTransport wraps the round tripper with one that creates a client span
+// for each request, which the exporter maps to a dependency telemetry with
+// the called host as its target, the url as its data and the status code as
+// its result code. The trace context is propagated to the called service.
+// If the base round tripper is nil, http.DefaultTransport is used.
+//
+// Requests that fail to get a response or get a response with a 4xx or 5xx
+// status code fail the span. The span ends when the response headers are
+// received.
+func NewTransport(base http.RoundTripper, opts ...Option) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	cfg := newConfig(opts)
+	return &transport{
+		base:       base,
+		tracer:     cfg.provider.Tracer(scopeName),
+		propagator: cfg.propagator,
+	}
+}
+
+// RoundTrip traces the request and sends it with the base round tripper.
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	attrs := []attribute.KeyValue{
+		urlKey.String(redactedURL(r)),
+		semconv.HTTPMethodKey.String(r.Method),
+		semconv.HTTPURLKey.String(redactedURL(r)),
+	}
+	host, port := r.URL.Hostname(), r.URL.Port()
+	if host != "" {
+		attrs = append(attrs, semconv.NetPeerNameKey.String(host))
+	}
+	if port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, semconv.NetPeerPortKey.Int(p))
+		}
+	}
+
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	ctx, span := t.tracer.Start(
+		r.Context(), r.Method+" "+path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	r = r.Clone(ctx)
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	res, err := t.base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, failure(err))
+		return res, err
+	}
+
+	span.SetAttributes(
+		responseCodeKey.String(strconv.Itoa(res.StatusCode)),
+		semconv.HTTPStatusCodeKey.Int(res.StatusCode),
+	)
+	if rc := res.Header.Get("Request-Context"); rc != "" {
+		span.SetAttributes(requestContextKey.String(rc))
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+	return res, nil
+}
+
+// failure classifies the transport error that prevented a response.
+func failure(err error) string {
+	var nerr net.Error
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &dnsErr):
+		return "dns lookup failed"
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return "timeout"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "connection failed"
+	case strings.Contains(err.Error(), "tls:") ||
+		strings.Contains(err.Error(), "x509:"):
+		return "tls handshake failed"
+	}
+	return err.Error()
+}
+
+// redactedURL returns the url of the request without user credentials, a
+// fragment and the values of query parameters, which can hold secrets such
+// as SAS tokens and API keys. The names of the parameters are kept.
+func redactedURL(r *http.Request) string {
+	u := *r.URL
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			if key, val, ok := strings.Cut(param, "="); ok && val != "" {
+				params[i] = key + "=" + redacted
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.String()
+}
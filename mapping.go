@@ -0,0 +1,72 @@
+package apex
+
+import (
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// scopeTypes maps fragments of well known instrumentation scope names to the
+// dependency type of the spans they create.
+var scopeTypes = []struct {
+	fragment string
+	depType  string
+}{
+	{"otelhttp", "HTTP"},
+	{"otelgrpc", "GRPC"},
+	{"otelsql", "SQL"},
+	{"otelgorm", "SQL"},
+	{"redisotel", "Redis"},
+	{"otelredis", "Redis"},
+	{"otelmongo", "MongoDB"},
+}
+
+// dependencyType selects the type of a dependency from the span's semantic
+// convention attributes, or from the name of the instrumentation scope that
+// created the span if no such attribute is present.
+func dependencyType(scope string, properties map[string]string) string {
+	if _, ok := properties[string(semconv.HTTPMethodKey)]; ok {
+		return "HTTP"
+	}
+	if val, ok := properties[string(semconv.RPCSystemKey)]; ok {
+		if val == "grpc" {
+			return "GRPC"
+		}
+		return val
+	}
+	if val, ok := properties[string(semconv.DBSystemKey)]; ok {
+		return dbType(val)
+	}
+	if val, ok := properties[string(semconv.MessagingSystemKey)]; ok {
+		return messagingType(val)
+	}
+
+	for _, e := range scopeTypes {
+		if strings.Contains(scope, e.fragment) {
+			return e.depType
+		}
+	}
+	return ""
+}
+
+// spanAttribute returns the value of the span's attribute formatted as a
+// string, including attributes that are not strings.
+func spanAttribute(sp sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range sp.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+// statusCode returns the http or grpc status code of the span's semantic
+// convention attributes.
+func statusCode(sp sdktrace.ReadOnlySpan) string {
+	if val := spanAttribute(sp, semconv.HTTPStatusCodeKey); val != "" {
+		return val
+	}
+	return spanAttribute(sp, semconv.RPCGRPCStatusCodeKey)
+}
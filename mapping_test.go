@@ -0,0 +1,283 @@
+package apex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Soreing/apex/httptrace"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestDependencyType tests that dependency types are selected accurately from
+// semantic convention attributes and instrumentation scopes
+func TestDependencyType(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Scope string
+		Props map[string]string
+		Type  string
+	}{
+		{
+			Name:  "Nothing to select from",
+			Scope: "",
+			Props: map[string]string{},
+			Type:  "",
+		},
+		{
+			Name:  "Http attribute",
+			Scope: "",
+			Props: map[string]string{"http.method": "GET"},
+			Type:  "HTTP",
+		},
+		{
+			Name:  "Grpc attribute",
+			Scope: "",
+			Props: map[string]string{"rpc.system": "grpc"},
+			Type:  "GRPC",
+		},
+		{
+			Name:  "Other rpc attribute",
+			Scope: "",
+			Props: map[string]string{"rpc.system": "dotnet_wcf"},
+			Type:  "dotnet_wcf",
+		},
+		{
+			Name:  "Database attribute",
+			Scope: "",
+			Props: map[string]string{"db.system": "postgresql"},
+			Type:  "SQL",
+		},
+		{
+			Name:  "Unknown database attribute",
+			Scope: "",
+			Props: map[string]string{"db.system": "couchbase"},
+			Type:  "couchbase",
+		},
+		{
+			Name:  "Messaging attribute",
+			Scope: "",
+			Props: map[string]string{"messaging.system": "kafka"},
+			Type:  "Queue Message | kafka",
+		},
+		{
+			Name:  "Attribute takes precedence over scope",
+			Scope: "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc",
+			Props: map[string]string{"http.method": "GET"},
+			Type:  "HTTP",
+		},
+		{
+			Name:  "Http scope",
+			Scope: "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp",
+			Props: map[string]string{},
+			Type:  "HTTP",
+		},
+		{
+			Name:  "Grpc scope",
+			Scope: "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc",
+			Props: map[string]string{},
+			Type:  "GRPC",
+		},
+		{
+			Name:  "Unknown scope",
+			Scope: "github.com/example/tracer",
+			Props: map[string]string{},
+			Type:  "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Type, dependencyType(test.Scope, test.Props))
+		})
+	}
+}
+
+// TestProcessScope tests that the instrumentation scope of spans is recorded
+// on the telemetry and used to select the dependency type
+func TestProcessScope(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Options []Option
+		Scope   instrumentation.Scope
+
+		TelType  string
+		TelProps map[string]string
+	}{
+		{
+			Name:     "No scope",
+			Options:  []Option{},
+			Scope:    instrumentation.Scope{},
+			TelType:  "",
+			TelProps: map[string]string{},
+		},
+		{
+			Name:    "Scope with default keys",
+			Options: []Option{},
+			Scope: instrumentation.Scope{
+				Name:    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp",
+				Version: "0.36.4",
+			},
+			TelType: "HTTP",
+			TelProps: map[string]string{
+				"otel.scope.name":    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp",
+				"otel.scope.version": "0.36.4",
+			},
+		},
+		{
+			Name:    "Scope with custom keys",
+			Options: []Option{WithScopeKeys("library", "")},
+			Scope: instrumentation.Scope{
+				Name:    "tracer",
+				Version: "1.0.0",
+			},
+			TelType: "",
+			TelProps: map[string]string{
+				"library": "tracer",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil, test.Options...)
+			exp.client = tcl
+
+			res, _ := resource.New(context.Background())
+			span := &mockSpan{
+				name:   "span",
+				kind:   trace.SpanKindClient,
+				status: sdktrace.Status{Code: codes.Ok},
+				res:    res,
+				attr:   []attribute.KeyValue{},
+				scope:  test.Scope,
+			}
+
+			exp.process(span)
+
+			assert.Equal(t, 1, len(tcl.tels))
+			assert.IsType(t, tcl.tels[0], (*appinsights.RemoteDependencyTelemetry)(nil))
+			tel := tcl.tels[0].(*appinsights.RemoteDependencyTelemetry)
+
+			assert.Equal(t, test.TelType, tel.Type)
+			assert.Equal(t, test.TelProps, tel.GetProperties())
+		})
+	}
+}
+
+// TestProcessHttptrace tests that spans of the httptrace instrumentation are
+// mapped to requests and dependencies without additional attributes
+func TestProcessHttptrace(t *testing.T) {
+	res, _ := resource.New(
+		context.Background(),
+		resource.WithAttributes(semconv.ServiceNameKey.String("checkout")),
+	)
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(sr),
+		sdktrace.WithResource(res),
+	)
+
+	srv := httptest.NewServer(httptrace.NewHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Request-Context", "appId=cid-v1:orders-app")
+			w.WriteHeader(http.StatusCreated)
+		}),
+		httptrace.WithTracerProvider(tp),
+		httptrace.WithRoute("/orders"),
+	))
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: httptrace.NewTransport(nil, httptrace.WithTracerProvider(tp)),
+	}
+	resp, err := client.Post(srv.URL+"/orders", "application/json", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	tcl := &mockTelemetryClient{}
+	exp, _ := NewExporter("", nil)
+	exp.client = tcl
+	for _, sp := range sr.Ended() {
+		exp.process(sp)
+	}
+	assert.Equal(t, 2, len(tcl.tels))
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	for _, tel := range tcl.tels {
+		switch tel := tel.(type) {
+		case *appinsights.RequestTelemetry:
+			assert.Equal(t, "POST /orders", tel.Name)
+			assert.Equal(t, srv.URL+"/orders", tel.Url)
+			assert.Equal(t, "201", tel.ResponseCode)
+			assert.True(t, tel.Success)
+			assert.Equal(t, "checkout", tel.ContextTags()["ai.cloud.role"])
+		case *appinsights.RemoteDependencyTelemetry:
+			assert.Equal(t, "POST /orders", tel.Name)
+			assert.Equal(t, "HTTP", tel.Type)
+			assert.Equal(t, host+" | cid-v1:orders-app", tel.Target)
+			assert.Equal(t, srv.URL+"/orders", tel.Data)
+			assert.Equal(t, "201", tel.ResultCode)
+			assert.True(t, tel.Success)
+			assert.Equal(t, "checkout", tel.ContextTags()["ai.cloud.role"])
+		default:
+			t.Errorf("unexpected telemetry %T", tel)
+		}
+	}
+}
+
+// TestProcessGrpc tests that spans with rpc attributes are mapped to GRPC
+// requests and dependencies with their status codes
+func TestProcessGrpc(t *testing.T) {
+	tcl := &mockTelemetryClient{}
+	exp, _ := NewExporter("", nil)
+	exp.client = tcl
+
+	res, _ := resource.New(context.Background())
+	attr := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("grpc"),
+		semconv.RPCServiceKey.String("orders.v1.Orders"),
+		semconv.RPCMethodKey.String("Get"),
+		semconv.NetPeerNameKey.String("orders.internal"),
+		semconv.NetPeerPortKey.Int(50051),
+		semconv.RPCGRPCStatusCodeKey.Int(5),
+	}
+
+	exp.process(&mockSpan{
+		name:   "orders.v1.Orders/Get",
+		kind:   trace.SpanKindClient,
+		status: sdktrace.Status{Code: codes.Error, Description: "not found"},
+		res:    res,
+		attr:   attr,
+	})
+	exp.process(&mockSpan{
+		name:   "orders.v1.Orders/Get",
+		kind:   trace.SpanKindServer,
+		status: sdktrace.Status{Code: codes.Ok},
+		res:    res,
+		attr:   attr,
+	})
+
+	assert.Equal(t, 2, len(tcl.tels))
+	dep := tcl.tels[0].(*appinsights.RemoteDependencyTelemetry)
+	assert.Equal(t, "GRPC", dep.Type)
+	assert.Equal(t, "orders.internal:50051", dep.Target)
+	assert.Equal(t, "5", dep.ResultCode)
+	assert.False(t, dep.Success)
+
+	req := tcl.tels[1].(*appinsights.RequestTelemetry)
+	assert.Equal(t, "5", req.ResponseCode)
+	assert.True(t, req.Success)
+}
@@ -0,0 +1,29 @@
+package apex
+
+import (
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// messagingType formats the dependency type of messages sent to a messaging
+// system, like Application Insights SDKs do.
+func messagingType(system string) string {
+	return "Queue Message | " + system
+}
+
+// messagingDestination returns the queue or topic of a messaging span, if
+// the span belongs to a messaging system.
+func messagingDestination(properties map[string]string) string {
+	if _, ok := properties[string(semconv.MessagingSystemKey)]; !ok {
+		return ""
+	}
+	return properties[string(semconv.MessagingDestinationKey)]
+}
+
+// isMessageReceive checks if the span receives messages from a messaging
+// system instead of processing them. Receiving a message is a call to the
+// messaging system, so it is exported as a dependency.
+func isMessageReceive(properties map[string]string) bool {
+	_, ok := properties[string(semconv.MessagingSystemKey)]
+	return ok && properties[string(semconv.MessagingOperationKey)] ==
+		semconv.MessagingOperationReceive.Value.AsString()
+}
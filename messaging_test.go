@@ -0,0 +1,108 @@
+package apex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestProcessMessaging tests that messaging spans are mapped to requests
+// from their queue or topic, and to dependencies targeting it
+func TestProcessMessaging(t *testing.T) {
+	tests := []struct {
+		Name string
+		Kind trace.SpanKind
+		Attr []attribute.KeyValue
+
+		Request   bool
+		TelSource string
+		TelType   string
+		TelTarget string
+	}{
+		{
+			Name: "Producer",
+			Kind: trace.SpanKindProducer,
+			Attr: []attribute.KeyValue{
+				semconv.MessagingSystemKey.String("kafka"),
+				semconv.MessagingDestinationKey.String("orders"),
+				semconv.NetPeerNameKey.String("broker.internal"),
+			},
+			TelType:   "Queue Message | kafka",
+			TelTarget: "orders",
+		},
+		{
+			Name: "Producer without destination",
+			Kind: trace.SpanKindProducer,
+			Attr: []attribute.KeyValue{
+				semconv.MessagingSystemKey.String("rabbitmq"),
+				semconv.NetPeerNameKey.String("broker.internal"),
+			},
+			TelType:   "Queue Message | rabbitmq",
+			TelTarget: "broker.internal",
+		},
+		{
+			Name: "Consumer processing",
+			Kind: trace.SpanKindConsumer,
+			Attr: []attribute.KeyValue{
+				semconv.MessagingSystemKey.String("kafka"),
+				semconv.MessagingDestinationKey.String("orders"),
+				semconv.MessagingOperationProcess,
+			},
+			Request:   true,
+			TelSource: "orders",
+		},
+		{
+			Name: "Consumer receiving",
+			Kind: trace.SpanKindConsumer,
+			Attr: []attribute.KeyValue{
+				semconv.MessagingSystemKey.String("kafka"),
+				semconv.MessagingDestinationKey.String("orders"),
+				semconv.MessagingOperationReceive,
+			},
+			TelType:   "Queue Message | kafka",
+			TelTarget: "orders",
+		},
+		{
+			Name:      "Consumer without messaging attributes",
+			Kind:      trace.SpanKindConsumer,
+			Attr:      []attribute.KeyValue{attribute.String("key", "orders")},
+			Request:   true,
+			TelSource: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil)
+			exp.client = tcl
+
+			res, _ := resource.New(context.Background())
+			exp.process(&mockSpan{
+				name:   "orders send",
+				kind:   test.Kind,
+				status: sdktrace.Status{Code: codes.Ok},
+				res:    res,
+				attr:   test.Attr,
+			})
+
+			assert.Equal(t, 1, len(tcl.tels))
+			if test.Request {
+				tel := tcl.tels[0].(*appinsights.RequestTelemetry)
+				assert.Equal(t, test.TelSource, tel.Source)
+				return
+			}
+			tel := tcl.tels[0].(*appinsights.RemoteDependencyTelemetry)
+			assert.Equal(t, test.TelType, tel.Type)
+			assert.Equal(t, test.TelTarget, tel.Target)
+		})
+	}
+}
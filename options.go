@@ -0,0 +1,235 @@
+package apex
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Option configures optional behaviour of an App Insights Exporter when it
+// is created.
+type Option func(*config)
+
+// config holds the settings collected from the options of an exporter.
+type config struct {
+	filters            []Filter
+	pageViews          []Filter
+	scopeNameKey       string
+	scopeVersionKey    string
+	statusExceptions   bool
+	storage            *storage
+	tokenSource        TokenSource
+	transport          transport
+	serviceName        string
+	sampling           float64
+	liveMetrics        bool
+	liveEndpoint       string
+	legacyParentIds    bool
+	sanitizeStatements bool
+	syntheticAgents    []string
+	syntheticHeaders   []string
+}
+
+// newConfig applies the options on a default configuration.
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		scopeNameKey:    "otel.scope.name",
+		scopeVersionKey: "otel.scope.version",
+		serviceName:     "unknown-service",
+		sampling:        100,
+		liveEndpoint:    defaultLiveEndpoint,
+		syntheticAgents: defaultSyntheticUserAgents,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithFilters adds rules to the exporter that prevent matching spans from
+// being exported. A span is dropped if it matches any of the filters.
+func WithFilters(filters ...Filter) Option {
+	return func(cfg *config) {
+		cfg.filters = append(cfg.filters, filters...)
+	}
+}
+
+// WithPageViews adds rules to the exporter that select spans to be exported
+// as page views instead of the telemetry of their kind, such as spans of
+// server side page renders. A span is a page view if it matches any of the
+// rules.
+func WithPageViews(rules ...Filter) Option {
+	return func(cfg *config) {
+		cfg.pageViews = append(cfg.pageViews, rules...)
+	}
+}
+
+// WithScopeKeys sets the property keys under which the name and version of
+// the span's instrumentation scope are recorded on the telemetry. Empty keys
+// prevent the values from being recorded. The default keys are
+// "otel.scope.name" and "otel.scope.version".
+func WithScopeKeys(nameKey, versionKey string) Option {
+	return func(cfg *config) {
+		cfg.scopeNameKey = nameKey
+		cfg.scopeVersionKey = versionKey
+	}
+}
+
+// WithStatusExceptions makes the exporter create an exception telemetry for
+// each span that ended with an error status but has no exception recorded,
+// using the status description as the exception's message.
+func WithStatusExceptions() Option {
+	return func(cfg *config) {
+		cfg.statusExceptions = true
+	}
+}
+
+// WithOfflineStorage makes the exporter persist batches of telemetry that
+// could not be sent due to transient ingestion failures in a directory, and
+// send them once ingestion is possible again, including on the next start.
+// The oldest batches are removed when the total size of the directory would
+// exceed maxSize bytes, and batches older than maxAge are discarded. Zero
+// limits default to 50MB and 48 hours.
+func WithOfflineStorage(
+	dir string,
+	maxSize int64,
+	maxAge time.Duration,
+) Option {
+	return func(cfg *config) {
+		cfg.storage = &storage{
+			dir:     dir,
+			maxSize: maxSize,
+			maxAge:  maxAge,
+		}
+	}
+}
+
+// WithTokenSource makes the exporter authenticate ingestion requests with
+// Microsoft Entra ID bearer tokens provided by the token source. Tokens are
+// cached until shortly before they expire.
+func WithTokenSource(source TokenSource) Option {
+	return func(cfg *config) {
+		cfg.tokenSource = source
+	}
+}
+
+// WithHTTPClient sets the http client used for all ingestion requests,
+// replacing the client of the telemetry configuration.
+func WithHTTPClient(client *http.Client) Option {
+	return func(cfg *config) {
+		cfg.transport.client = client
+	}
+}
+
+// WithRoundTripper sets the round tripper of the http client used for all
+// ingestion requests.
+func WithRoundTripper(rt http.RoundTripper) Option {
+	return func(cfg *config) {
+		cfg.transport.roundTrip = rt
+	}
+}
+
+// WithProxy routes all ingestion requests through the proxy at the url. The
+// round tripper of the http client must be an *http.Transport, if set.
+func WithProxy(proxyURL string) Option {
+	return func(cfg *config) {
+		cfg.transport.proxy = proxyURL
+	}
+}
+
+// WithRootCAs sets the certificate authorities used to verify the servers
+// of ingestion requests. The round tripper of the http client must be an
+// *http.Transport, if set.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(cfg *config) {
+		cfg.transport.rootCAs = pool
+	}
+}
+
+// WithRequestTimeout limits the time an ingestion request can take,
+// including reading the response.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.transport.timeout = timeout
+	}
+}
+
+// WithServiceName sets the cloud role of telemetry created from spans whose
+// resource does not have a service name. The default is "unknown-service".
+func WithServiceName(name string) Option {
+	return func(cfg *config) {
+		cfg.serviceName = name
+	}
+}
+
+// WithSamplingPercentage makes the exporter keep only the given percentage
+// of traces, between 0 and 100. Traces are selected by their trace id, so
+// all spans of a trace are either kept or dropped together, consistently
+// with the sampling of other Application Insights SDKs.
+func WithSamplingPercentage(percentage float64) Option {
+	return func(cfg *config) {
+		cfg.sampling = percentage
+	}
+}
+
+// WithLiveMetrics streams request, dependency and exception rates of the
+// exported spans to the Live Metrics blade of Application Insights. The live
+// endpoint is taken from the connection string when the exporter is created
+// from one. The instance reports the service name of the resource of the
+// exported spans as its role, like their telemetry.
+func WithLiveMetrics() Option {
+	return func(cfg *config) {
+		cfg.liveMetrics = true
+	}
+}
+
+// withLiveEndpoint sets the endpoint of the live metrics service.
+func withLiveEndpoint(endpoint string) Option {
+	return func(cfg *config) {
+		cfg.liveEndpoint = endpoint
+	}
+}
+
+// WithLegacyParentIds sets the operation parent id of root spans to their
+// trace id, as earlier versions of the exporter did, instead of leaving it
+// empty.
+func WithLegacyParentIds() Option {
+	return func(cfg *config) {
+		cfg.legacyParentIds = true
+	}
+}
+
+// WithSanitizedStatements removes literals from the statements of database
+// dependencies before they are sent, so that values such as credentials or
+// personal data in queries do not leave the process.
+func WithSanitizedStatements() Option {
+	return func(cfg *config) {
+		cfg.sanitizeStatements = true
+	}
+}
+
+// WithSyntheticUserAgents adds fragments of user agents that mark requests
+// as synthetic traffic from bots, in addition to those of common crawlers
+// and monitoring services. Fragments are matched case insensitively.
+func WithSyntheticUserAgents(fragments ...string) Option {
+	return func(cfg *config) {
+		agents := make([]string, 0, len(cfg.syntheticAgents)+len(fragments))
+		agents = append(agents, cfg.syntheticAgents...)
+		for _, f := range fragments {
+			agents = append(agents, strings.ToLower(f))
+		}
+		cfg.syntheticAgents = agents
+	}
+}
+
+// WithSyntheticHeaders adds request headers whose presence marks requests as
+// synthetic traffic, such as headers set by load tests. The synthetic source
+// of the requests is the name of the header. Headers must be captured as
+// "http.request.header.*" attributes by the instrumentation, such as with
+// httptrace.WithRequestHeaders.
+func WithSyntheticHeaders(headers ...string) Option {
+	return func(cfg *config) {
+		cfg.syntheticHeaders = append(cfg.syntheticHeaders, headers...)
+	}
+}
@@ -0,0 +1,93 @@
+package apex
+
+import (
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// pageViewTelemetry is a page view telemetry item with an id, so that the
+// telemetry of spans created within the page view can refer to it as their
+// parent. appinsights.PageViewTelemetry does not have an id.
+type pageViewTelemetry struct {
+	appinsights.PageViewTelemetry
+	Id string
+}
+
+// pageViewData is the data of a page view with its id, which
+// contracts.PageViewData does not have.
+type pageViewData struct {
+	*contracts.PageViewData
+	Id string `json:"id"`
+}
+
+// TelemetryData returns the data of the page view that is sent to
+// Application Insights.
+func (t *pageViewTelemetry) TelemetryData() appinsights.TelemetryData {
+	return &pageViewData{
+		PageViewData: t.PageViewTelemetry.TelemetryData().(*contracts.PageViewData),
+		Id:           t.Id,
+	}
+}
+
+// isPageView checks if the span matches any of the exporter's page view
+// rules and should be exported as a page view.
+func (exp *AppInsightsExporter) isPageView(sp sdktrace.ReadOnlySpan) bool {
+	for i := range exp.pageViews {
+		if exp.pageViews[i].match(sp) {
+			return true
+		}
+	}
+	return false
+}
+
+// processPageView constructs the telemetry for a span selected by the page
+// view rules and dispatches it to the application insights telemetry client.
+//
+// Application Insights specific fields are sourced from custom properties:
+// Role = properties["service.name"]
+// Url = properties["url"], or properties["http.url"]
+func (exp *AppInsightsExporter) processPageView(
+	sp sdktrace.ReadOnlySpan,
+	properties map[string]string,
+	measurements map[string]float64,
+) {
+	tele := pageViewTelemetry{
+		PageViewTelemetry: appinsights.PageViewTelemetry{
+			Name:     sp.Name(),
+			Url:      "",
+			Duration: sp.EndTime().Sub(sp.StartTime()),
+			BaseTelemetry: appinsights.BaseTelemetry{
+				Timestamp:  sp.StartTime(),
+				Tags:       make(contracts.ContextTags),
+				Properties: map[string]string{},
+			},
+			BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
+				Measurements: measurements,
+			},
+		},
+		Id: sp.SpanContext().SpanID().String(),
+	}
+	tele.Tags.Cloud().SetRole(exp.serviceName)
+	if val, ok := properties[string(semconv.ServiceNameKey)]; ok {
+		delete(properties, string(semconv.ServiceNameKey))
+		tele.Tags.Cloud().SetRole(val)
+	}
+	if val, ok := properties["url"]; ok {
+		delete(properties, "url")
+		tele.Url = val
+	} else if val, ok := properties[string(semconv.HTTPURLKey)]; ok {
+		tele.Url = val
+	}
+	if desc := sp.Status().Description; desc != "" {
+		properties[statusDescriptionKey] = desc
+	}
+	tele.BaseTelemetry.Properties = properties
+
+	tele.Tags.Operation().SetId(operationId(sp.SpanContext()))
+	tele.Tags.Operation().SetParentId(exp.parentId(sp))
+	tele.Tags.Operation().SetName(sp.Name())
+
+	exp.track(&tele)
+}
@@ -0,0 +1,120 @@
+package apex
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestProcessPageView tests that spans matching the page view rules are
+// mapped to page views, while other spans keep the telemetry of their kind
+func TestProcessPageView(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		Name  string
+		Rules []Filter
+		Span  string
+		Kind  trace.SpanKind
+		Attr  []attribute.KeyValue
+
+		PageView      bool
+		TelUrl        string
+		TelProperties map[string]string
+	}{
+		{
+			Name:  "Name rule",
+			Rules: []Filter{{Names: []string{"render *"}}},
+			Span:  "render /home",
+			Kind:  trace.SpanKindInternal,
+			Attr: []attribute.KeyValue{
+				attribute.String("url", "https://example.com/home"),
+				attribute.String("template", "home.html"),
+			},
+			PageView:      true,
+			TelUrl:        "https://example.com/home",
+			TelProperties: map[string]string{"template": "home.html"},
+		},
+		{
+			Name: "Attribute rule",
+			Rules: []Filter{{Attributes: []AttributeFilter{
+				{Key: "page", Value: "true"},
+			}}},
+			Span: "GET /home",
+			Kind: trace.SpanKindServer,
+			Attr: []attribute.KeyValue{
+				attribute.Bool("page", true),
+				semconv.HTTPURLKey.String("https://example.com/home?tab=1"),
+			},
+			PageView: true,
+			TelUrl:   "https://example.com/home?tab=1",
+		},
+		{
+			Name:     "Not matching",
+			Rules:    []Filter{{Names: []string{"render *"}}},
+			Span:     "GET /home",
+			Kind:     trace.SpanKindServer,
+			PageView: false,
+		},
+		{
+			Name:     "No rules",
+			Span:     "render /home",
+			Kind:     trace.SpanKindInternal,
+			PageView: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil, WithPageViews(test.Rules...))
+			exp.client = tcl
+
+			res, _ := resource.New(context.Background())
+			exp.process(&mockSpan{
+				name:      test.Span,
+				kind:      test.Kind,
+				status:    sdktrace.Status{Code: codes.Ok},
+				startTime: start,
+				endTime:   start.Add(250 * time.Millisecond),
+				traceId:   [16]byte{1},
+				parentId:  [8]byte{2},
+				spanId:    [8]byte{3},
+				res:       res,
+				attr:      test.Attr,
+			})
+
+			assert.Equal(t, 1, len(tcl.tels))
+			tel, ok := tcl.tels[0].(*pageViewTelemetry)
+			assert.Equal(t, test.PageView, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, uint64(1), exp.Stats().PageViewsTracked)
+			assert.Equal(t, "0300000000000000", tel.Id)
+			data, _ := json.Marshal(tel.TelemetryData())
+			assert.Contains(t, string(data), `"id":"0300000000000000"`)
+			assert.Contains(t, string(data), `"url":"`+test.TelUrl+`"`)
+			assert.Equal(t, "PageViewData", tel.TelemetryData().BaseType())
+			assert.Equal(t, test.Span, tel.Name)
+			assert.Equal(t, test.TelUrl, tel.Url)
+			assert.Equal(t, 250*time.Millisecond, tel.Duration)
+			assert.Equal(t, start, tel.Timestamp)
+			assert.Equal(t, "unknown-service", tel.Tags.Cloud().GetRole())
+			assert.Equal(t, "01000000000000000000000000000000", tel.Tags.Operation().GetId())
+			assert.Equal(t, "0200000000000000", tel.Tags.Operation().GetParentId())
+			assert.NotContains(t, tel.Properties, "url")
+			for k, v := range test.TelProperties {
+				assert.Equal(t, v, tel.Properties[k])
+			}
+		})
+	}
+}
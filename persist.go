@@ -0,0 +1,145 @@
+package apex
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default limits of the offline storage.
+const (
+	defaultStorageSize = 50 * 1024 * 1024
+	defaultStorageAge  = 48 * time.Hour
+)
+
+// storageExt is the extension of files holding persisted telemetry batches.
+const storageExt = ".trn"
+
+// storage describes the location and limits of the offline storage.
+type storage struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+}
+
+// diskStore persists batches of telemetry in a directory, keeping the total
+// size of the stored files under a limit and expiring old batches.
+type diskStore struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	mtx     sync.Mutex
+}
+
+// newDiskStore creates a disk store in the directory, creating the directory
+// if it does not exist.
+func newDiskStore(st *storage) (*diskStore, error) {
+	if st.dir == "" {
+		return nil, errors.New("storage directory is empty")
+	}
+	if err := os.MkdirAll(st.dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	ds := &diskStore{
+		dir:     st.dir,
+		maxSize: st.maxSize,
+		maxAge:  st.maxAge,
+	}
+	if ds.maxSize <= 0 {
+		ds.maxSize = defaultStorageSize
+	}
+	if ds.maxAge <= 0 {
+		ds.maxAge = defaultStorageAge
+	}
+	return ds, nil
+}
+
+// storedFile is a persisted batch found in the store's directory.
+type storedFile struct {
+	name string
+	size int64
+}
+
+// list returns the persisted batches in the store ordered from oldest to
+// newest, removing the ones that have expired.
+func (ds *diskStore) list() ([]storedFile, error) {
+	entries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []storedFile{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), storageExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > ds.maxAge {
+			os.Remove(filepath.Join(ds.dir, e.Name()))
+			continue
+		}
+		files = append(files, storedFile{
+			name: e.Name(),
+			size: info.Size(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files, nil
+}
+
+// save persists a batch in the store. The oldest batches are removed if the
+// store would exceed its size limit.
+func (ds *diskStore) save(payload []byte) error {
+	ds.mtx.Lock()
+	defer ds.mtx.Unlock()
+
+	size := int64(len(payload))
+	if size > ds.maxSize {
+		return errors.New("payload exceeds storage size")
+	}
+
+	files, err := ds.list()
+	if err != nil {
+		return err
+	}
+
+	total := size
+	for _, f := range files {
+		total += f.size
+	}
+	for i := 0; total > ds.maxSize && i < len(files); i++ {
+		os.Remove(filepath.Join(ds.dir, files[i].name))
+		total -= files[i].size
+	}
+
+	name := fmt.Sprintf("%020d-%08x", time.Now().UnixNano(), rand.Uint32())
+	tmp := filepath.Join(ds.dir, name+".tmp")
+	if err := os.WriteFile(tmp, payload, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(ds.dir, name+storageExt))
+}
+
+// read returns the content of a persisted batch.
+func (ds *diskStore) read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(ds.dir, name))
+}
+
+// remove deletes a persisted batch from the store.
+func (ds *diskStore) remove(name string) error {
+	return os.Remove(filepath.Join(ds.dir, name))
+}
@@ -0,0 +1,123 @@
+package apex
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestDiskStore tests that the disk store keeps persisted batches within its
+// size and age limits
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	ds, err := newDiskStore(&storage{dir: dir, maxSize: 10, maxAge: time.Hour})
+	assert.Nil(t, err)
+
+	assert.Nil(t, ds.save([]byte("aaaa")))
+	assert.Nil(t, ds.save([]byte("bbbb")))
+	assert.NotNil(t, ds.save([]byte("payload too large")))
+
+	files, err := ds.list()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(files))
+
+	assert.Nil(t, ds.save([]byte("cccc")))
+	files, _ = ds.list()
+	assert.Equal(t, 2, len(files))
+	first, _ := ds.read(files[0].name)
+	second, _ := ds.read(files[1].name)
+	assert.Equal(t, "bbbb", string(first))
+	assert.Equal(t, "cccc", string(second))
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, files[0].name), old, old)
+	files, _ = ds.list()
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, 4, int(files[0].size))
+
+	_, err = newDiskStore(&storage{})
+	assert.NotNil(t, err)
+}
+
+// TestOfflineStorage tests that telemetry failing to be sent is persisted and
+// replayed by the next exporter using the same storage
+func TestOfflineStorage(t *testing.T) {
+	status := atomic.Int64{}
+	status.Store(http.StatusServiceUnavailable)
+	received := atomic.Int64{}
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			code := int(status.Load())
+			if code == http.StatusOK {
+				received.Add(1)
+			}
+			w.WriteHeader(code)
+		},
+	))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	logger := func(msg string) error { return nil }
+	newExp := func() *AppInsightsExporter {
+		cfg := appinsights.NewTelemetryConfiguration("")
+		cfg.EndpointUrl = srv.URL
+		exp, err := NewExporterFromConfig(
+			cfg, logger, WithOfflineStorage(dir, 0, 0),
+		)
+		assert.Nil(t, err)
+		exp.client.Channel().(*batchChannel).backoff = []time.Duration{
+			time.Millisecond,
+		}
+		return exp
+	}
+
+	res, _ := resource.New(context.Background())
+	spans := []sdktrace.ReadOnlySpan{
+		&mockSpan{
+			name:   "span",
+			kind:   trace.SpanKindServer,
+			status: sdktrace.Status{Code: codes.Ok},
+			res:    res,
+			attr:   []attribute.KeyValue{},
+		},
+	}
+
+	exp := newExp()
+	assert.Nil(t, exp.ExportSpans(context.Background(), spans))
+	assert.Nil(t, exp.Shutdown(context.Background()))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, int64(0), received.Load())
+	assert.Equal(t, uint64(1), exp.Stats().BatchesPersisted)
+	assert.Equal(t, uint64(0), exp.Stats().BatchesDropped)
+	assert.Equal(t, uint64(0), exp.Stats().ItemsSent)
+
+	status.Store(http.StatusOK)
+	exp = newExp()
+	assert.Eventually(t, func() bool {
+		entries, _ := os.ReadDir(dir)
+		return len(entries) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), received.Load())
+	assert.Eventually(t, func() bool {
+		return exp.Stats().ItemsSent == 1
+	}, time.Second, time.Millisecond)
+	assert.Nil(t, exp.Shutdown(context.Background()))
+}
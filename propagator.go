@@ -0,0 +1,185 @@
+package apex
+
+import (
+	"context"
+	"encoding/base64"
+	"hash/fnv"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Headers of the legacy Application Insights correlation protocol.
+const (
+	requestIdHeader      = "Request-Id"
+	requestContextHeader = "Request-Context"
+)
+
+// appIdPrefix precedes the application id in Request-Context headers.
+const appIdPrefix = "cid-v1:"
+
+// legacyRequestIdKey is the trace state key of the Request-Id of a legacy
+// caller whose ids are not W3C ids. It is encoded with base64, since trace
+// state values can not contain all the characters of Request-Ids.
+const legacyRequestIdKey = "ai-request-id"
+
+// requestContextKey is the context key of the application id extracted from
+// a Request-Context header.
+type requestContextKey struct{}
+
+// RequestIdPropagator propagates span contexts with the Request-Id and
+// Request-Context headers of the legacy Application Insights correlation
+// protocol, used by older .NET and Node.js SDKs.
+//
+// Hierarchical Request-Ids whose root is not a W3C trace id are mapped to
+// trace ids derived from the root, so that all spans of the operation share
+// a trace. The Request-Id is kept in the trace state of the span context,
+// so that the exporter correlates the telemetry of the operation with the
+// root and the Request-Id of the caller rather than the derived ids. The
+// propagator does not override span contexts extracted by other
+// propagators, so it should be placed after propagation.TraceContext in a
+// composite propagator.
+type RequestIdPropagator struct {
+	// AppId is the Application Insights application id sent in the
+	// Request-Context header of outgoing requests.
+	AppId string
+}
+
+var _ propagation.TextMapPropagator = RequestIdPropagator{}
+
+// Inject sets the Request-Id and Request-Context headers from the span
+// context in the context.
+func (p RequestIdPropagator) Inject(
+	ctx context.Context,
+	carrier propagation.TextMapCarrier,
+) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	carrier.Set(requestIdHeader, "|"+sc.TraceID().String()+"."+sc.SpanID().String()+".")
+	if p.AppId != "" {
+		carrier.Set(requestContextHeader, "appId="+appIdPrefix+p.AppId)
+	}
+}
+
+// Extract reads the span context from the Request-Id header and the caller's
+// application id from the Request-Context header into the context.
+func (p RequestIdPropagator) Extract(
+	ctx context.Context,
+	carrier propagation.TextMapCarrier,
+) context.Context {
+	if appId := parseRequestContext(carrier.Get(requestContextHeader)); appId != "" {
+		ctx = context.WithValue(ctx, requestContextKey{}, appId)
+	}
+
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	if sc := parseRequestId(carrier.Get(requestIdHeader)); sc.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	return ctx
+}
+
+// Fields returns the headers the propagator sets.
+func (p RequestIdPropagator) Fields() []string {
+	return []string{requestIdHeader, requestContextHeader}
+}
+
+// RequestContextAppId returns the application id of the caller extracted
+// from a Request-Context header, if there was one.
+func RequestContextAppId(ctx context.Context) string {
+	appId, _ := ctx.Value(requestContextKey{}).(string)
+	return appId
+}
+
+// parseRequestId maps a Request-Id to a span context. The root of the id is
+// used as the trace id if it is a W3C trace id, and the last segment as the
+// span id if it is a W3C span id. Otherwise, ids are derived from them, and
+// the Request-Id is added to the trace state if it fits.
+func parseRequestId(id string) trace.SpanContext {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return trace.SpanContext{}
+	}
+
+	trimmed := strings.TrimRight(strings.TrimPrefix(id, "|"), "._")
+	if trimmed == "" {
+		return trace.SpanContext{}
+	}
+	root, _, _ := strings.Cut(trimmed, ".")
+	last := trimmed[strings.LastIndexAny(trimmed, "._")+1:]
+
+	derived := false
+	traceId, err := trace.TraceIDFromHex(strings.ToLower(root))
+	if err != nil {
+		h := fnv.New128a()
+		h.Write([]byte(root))
+		copy(traceId[:], h.Sum(nil))
+		derived = true
+	}
+
+	spanId, err := trace.SpanIDFromHex(strings.ToLower(last))
+	if err != nil || last == root {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		copy(spanId[:], h.Sum(nil))
+		derived = true
+	}
+
+	ts := trace.TraceState{}
+	if derived {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(id))
+		if inserted, err := ts.Insert(legacyRequestIdKey, encoded); err == nil {
+			ts = inserted
+		}
+	}
+
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     spanId,
+		TraceFlags: trace.FlagsSampled,
+		TraceState: ts,
+		Remote:     true,
+	})
+}
+
+// legacyRequestId returns the Request-Id of the legacy caller kept in the
+// trace state of the span context, and the root of the Request-Id if the
+// trace id of the span context was derived from it. Trace states passed on
+// to other services are ignored, since their trace ids do not match.
+func legacyRequestId(sc trace.SpanContext) (string, string) {
+	encoded := sc.TraceState().Get(legacyRequestIdKey)
+	if encoded == "" {
+		return "", ""
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ""
+	}
+
+	id := string(data)
+	legacy := parseRequestId(id)
+	if !legacy.IsValid() || legacy.TraceID() != sc.TraceID() {
+		return "", ""
+	}
+	trimmed := strings.TrimRight(strings.TrimPrefix(strings.TrimSpace(id), "|"), "._")
+	root, _, _ := strings.Cut(trimmed, ".")
+	return id, root
+}
+
+// parseRequestContext returns the application id in a Request-Context
+// header, made of comma separated key=value pairs.
+func parseRequestContext(header string) string {
+	for _, pair := range strings.Split(header, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "appId") {
+			val = strings.TrimSpace(val)
+			return strings.TrimPrefix(val, appIdPrefix)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package apex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestParseRequestId tests that Request-Ids are mapped to span contexts
+func TestParseRequestId(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Id      string
+		Valid   bool
+		TraceId string
+		SpanId  string
+		Legacy  bool
+	}{
+		{
+			Name:    "W3C compatible id",
+			Id:      "|4bf92f3577b34da6a3ce929d0e0e4736.00f067aa0ba902b7.",
+			Valid:   true,
+			TraceId: "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanId:  "00f067aa0ba902b7",
+		},
+		{
+			Name:    "W3C compatible id in upper case",
+			Id:      "|4BF92F3577B34DA6A3CE929D0E0E4736.00F067AA0BA902B7.",
+			Valid:   true,
+			TraceId: "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanId:  "00f067aa0ba902b7",
+		},
+		{
+			Name:    "Hierarchical id with trace id root",
+			Id:      "|4bf92f3577b34da6a3ce929d0e0e4736.1.2_3.",
+			Valid:   true,
+			TraceId: "4bf92f3577b34da6a3ce929d0e0e4736",
+			Legacy:  true,
+		},
+		{
+			Name:   "Hierarchical id",
+			Id:     "|Kb3BtP1a+Fk=.8d4b2c1e_1.",
+			Valid:  true,
+			Legacy: true,
+		},
+		{
+			Name:   "Flat id",
+			Id:     "Kb3BtP1a+Fk=",
+			Valid:  true,
+			Legacy: true,
+		},
+		{
+			Name: "Empty id",
+			Id:   "",
+		},
+		{
+			Name: "Separators only",
+			Id:   "|.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			sc := parseRequestId(test.Id)
+			assert.Equal(t, test.Valid, sc.IsValid())
+			if !test.Valid {
+				return
+			}
+			assert.True(t, sc.IsRemote())
+			assert.True(t, sc.IsSampled())
+			if test.TraceId != "" {
+				assert.Equal(t, test.TraceId, sc.TraceID().String())
+			}
+			if test.SpanId != "" {
+				assert.Equal(t, test.SpanId, sc.SpanID().String())
+			}
+			id, _ := legacyRequestId(sc)
+			if test.Legacy {
+				assert.Equal(t, test.Id, id)
+			} else {
+				assert.Equal(t, "", id)
+			}
+		})
+	}
+
+	a := parseRequestId("|Kb3BtP1a+Fk=.1.")
+	b := parseRequestId("|Kb3BtP1a+Fk=.2.")
+	assert.Equal(t, a.TraceID(), b.TraceID())
+	assert.NotEqual(t, a.SpanID(), b.SpanID())
+}
+
+// TestRequestIdPropagator tests that span contexts and application ids are
+// injected into and extracted from Request-Id and Request-Context headers
+func TestRequestIdPropagator(t *testing.T) {
+	prop := RequestIdPropagator{AppId: "app"}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	carrier := propagation.MapCarrier{}
+	prop.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+	assert.Equal(t, "|4bf92f3577b34da6a3ce929d0e0e4736.00f067aa0ba902b7.", carrier.Get("Request-Id"))
+	assert.Equal(t, "appId=cid-v1:app", carrier.Get("Request-Context"))
+
+	empty := propagation.MapCarrier{}
+	prop.Inject(context.Background(), empty)
+	assert.Empty(t, empty)
+
+	ctx := prop.Extract(context.Background(), propagation.MapCarrier{
+		"Request-Id":      "|4bf92f3577b34da6a3ce929d0e0e4736.00f067aa0ba902b7.",
+		"Request-Context": "roleName=orders, appId=cid-v1:caller",
+	})
+	extracted := trace.SpanContextFromContext(ctx)
+	assert.Equal(t, sc.TraceID(), extracted.TraceID())
+	assert.Equal(t, sc.SpanID(), extracted.SpanID())
+	assert.True(t, extracted.IsRemote())
+	assert.Equal(t, "caller", RequestContextAppId(ctx))
+
+	composite := propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, prop,
+	)
+	ctx = composite.Extract(context.Background(), propagation.MapCarrier{
+		"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"Request-Id":  "|4bf92f3577b34da6a3ce929d0e0e4736.00f067aa0ba902b7.",
+	})
+	extracted = trace.SpanContextFromContext(ctx)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", extracted.TraceID().String())
+	assert.Equal(t, "", RequestContextAppId(ctx))
+
+	assert.Equal(t, []string{"Request-Id", "Request-Context"}, prop.Fields())
+}
+
+// TestLegacyCorrelation tests that the telemetry of operations started by
+// legacy callers has the root and the Request-Id of the caller as the
+// operation id and parent id
+func TestLegacyCorrelation(t *testing.T) {
+	tcl := &mockTelemetryClient{}
+	exp, _ := NewExporter("", nil)
+	exp.client = tcl
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	tracer := tp.Tracer("test")
+
+	prop := propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, RequestIdPropagator{},
+	)
+	ctx := prop.Extract(context.Background(), propagation.MapCarrier{
+		"Request-Id": "|Kb3BtP1a+Fk=.8d4b2c1e_1.",
+	})
+
+	ctx, req := tracer.Start(ctx, "GET /orders", trace.WithSpanKind(trace.SpanKindServer))
+	_, dep := tracer.Start(ctx, "SELECT orders", trace.WithSpanKind(trace.SpanKindClient))
+	dep.SetStatus(codes.Ok, "")
+	dep.End()
+	req.SetStatus(codes.Ok, "")
+	req.End()
+
+	assert.Equal(t, 2, len(tcl.tels))
+	dtel := tcl.tels[0].(*appinsights.RemoteDependencyTelemetry)
+	rtel := tcl.tels[1].(*appinsights.RequestTelemetry)
+	assert.Equal(t, "Kb3BtP1a+Fk=", rtel.Tags.Operation().GetId())
+	assert.Equal(t, "|Kb3BtP1a+Fk=.8d4b2c1e_1.", rtel.Tags.Operation().GetParentId())
+	assert.Equal(t, "Kb3BtP1a+Fk=", dtel.Tags.Operation().GetId())
+	assert.Equal(t, rtel.Id, dtel.Tags.Operation().GetParentId())
+
+	// The trace state is passed on to other services with the trace context,
+	// where the trace id is the same but the parent is not the legacy caller.
+	carrier := propagation.MapCarrier{}
+	prop.Inject(ctx, carrier)
+	downstream := prop.Extract(context.Background(), carrier)
+	_, callee := tracer.Start(downstream, "GET /stock", trace.WithSpanKind(trace.SpanKindServer))
+	callee.SetStatus(codes.Ok, "")
+	callee.End()
+
+	assert.Equal(t, 3, len(tcl.tels))
+	ctel := tcl.tels[2].(*appinsights.RequestTelemetry)
+	assert.Equal(t, "Kb3BtP1a+Fk=", ctel.Tags.Operation().GetId())
+	assert.Equal(t, rtel.Id, ctel.Tags.Operation().GetParentId())
+
+	// Trace states of other traces are ignored.
+	other := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceState: trace.SpanContextFromContext(ctx).TraceState(),
+	})
+	assert.Equal(t, "01000000000000000000000000000000", operationId(other))
+}
@@ -0,0 +1,363 @@
+package apex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+)
+
+// Intervals of the live metrics protocol. The service is pinged until a
+// viewer subscribes to the stream, then metrics are posted every second.
+const (
+	livePingInterval = 5 * time.Second
+	livePostInterval = time.Second
+	liveTimeout      = 5 * time.Second
+)
+
+// Headers of the live metrics protocol.
+const (
+	qpsStreamIdHeader         = "x-ms-qps-stream-id"
+	qpsMachineNameHeader      = "x-ms-qps-machine-name"
+	qpsInstanceNameHeader     = "x-ms-qps-instance-name"
+	qpsRoleNameHeader         = "x-ms-qps-role-name"
+	qpsInvariantVersionHeader = "x-ms-qps-invariant-version"
+	qpsTransmissionTimeHeader = "x-ms-qps-transmission-time"
+	qpsSubscribedHeader       = "x-ms-qps-subscribed"
+	qpsPollingIntervalHeader  = "x-ms-qps-service-polling-interval-hint"
+	qpsRedirectHeader         = "x-ms-qps-service-endpoint-redirect-v2"
+)
+
+// Names of the metrics reported to live metrics.
+const (
+	qpsRequestRate           = `\ApplicationInsights\Requests/Sec`
+	qpsRequestDuration       = `\ApplicationInsights\Request Duration`
+	qpsRequestFailedRate     = `\ApplicationInsights\Requests Failed/Sec`
+	qpsRequestSucceededRate  = `\ApplicationInsights\Requests Succeeded/Sec`
+	qpsDependencyRate        = `\ApplicationInsights\Dependency Calls/Sec`
+	qpsDependencyDuration    = `\ApplicationInsights\Dependency Call Duration`
+	qpsDependencyFailedRate  = `\ApplicationInsights\Dependency Calls Failed/Sec`
+	qpsDependencySucceedRate = `\ApplicationInsights\Dependency Calls Succeeded/Sec`
+	qpsExceptionRate         = `\ApplicationInsights\Exceptions/Sec`
+)
+
+// liveMetric is a metric value in a live metrics data point.
+type liveMetric struct {
+	Name   string  `json:"Name"`
+	Value  float64 `json:"Value"`
+	Weight int     `json:"Weight"`
+}
+
+// liveDataPoint is the document exchanged with the live metrics service.
+type liveDataPoint struct {
+	Version                        string       `json:"Version"`
+	InvariantVersion               int          `json:"InvariantVersion"`
+	Instance                       string       `json:"Instance"`
+	RoleName                       string       `json:"RoleName"`
+	MachineName                    string       `json:"MachineName"`
+	StreamId                       string       `json:"StreamId"`
+	Timestamp                      string       `json:"Timestamp"`
+	IsWebApp                       bool         `json:"IsWebApp"`
+	PerformanceCollectionSupported bool         `json:"PerformanceCollectionSupported"`
+	Metrics                        []liveMetric `json:"Metrics"`
+}
+
+// liveAccumulator aggregates telemetry between two live metrics posts.
+type liveAccumulator struct {
+	start              time.Time
+	requests           int
+	requestsFailed     int
+	requestDuration    time.Duration
+	dependencies       int
+	dependenciesFailed int
+	dependencyDuration time.Duration
+	exceptions         int
+}
+
+// liveMetrics is a live metrics (QuickPulse) client that streams request,
+// dependency and exception rates of the exported telemetry.
+type liveMetrics struct {
+	endpoint     string
+	ikey         string
+	client       *http.Client
+	tokens       *tokenCache
+	logger       func(msg string) error
+	streamId     string
+	machine      string
+	pingInterval time.Duration
+	postInterval time.Duration
+
+	mtx        sync.Mutex
+	role       string
+	acc        liveAccumulator
+	subscribed atomic.Bool
+
+	stopOnce sync.Once
+	stops    chan struct{}
+	done     chan struct{}
+}
+
+// newLiveMetrics creates a live metrics client that reports to the endpoint
+// of the live metrics service for the instrumentation key.
+func newLiveMetrics(
+	endpoint string,
+	ikey string,
+	role string,
+	client *http.Client,
+	tokens *tokenCache,
+	logger func(msg string) error,
+) *liveMetrics {
+	machine, _ := os.Hostname()
+	return &liveMetrics{
+		endpoint:     endpoint,
+		ikey:         ikey,
+		client:       client,
+		tokens:       tokens,
+		logger:       logger,
+		streamId:     newOperationId(),
+		machine:      machine,
+		role:         role,
+		pingInterval: livePingInterval,
+		postInterval: livePostInterval,
+		acc:          liveAccumulator{start: time.Now()},
+		stops:        make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// start starts pinging the live metrics service in the background.
+func (lm *liveMetrics) start() {
+	go lm.run()
+}
+
+// stop stops the live metrics client and waits for it to finish.
+func (lm *liveMetrics) stop() {
+	lm.stopOnce.Do(func() { close(lm.stops) })
+	<-lm.done
+}
+
+// run pings the service until a viewer subscribes, then posts metrics until
+// the viewer leaves or the service stops accepting them.
+func (lm *liveMetrics) run() {
+	defer close(lm.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-lm.stops:
+			return
+		case <-timer.C:
+		}
+
+		var next time.Duration
+		if lm.subscribed.Load() {
+			next = lm.post()
+		} else {
+			next = lm.ping()
+		}
+		timer.Reset(next)
+	}
+}
+
+// ping checks if a viewer is subscribed to the stream, and returns when the
+// service should be contacted next.
+func (lm *liveMetrics) ping() time.Duration {
+	res, err := lm.send("ping", lm.dataPoint(nil))
+	if err != nil {
+		lm.log("Live metrics ping failed: " + err.Error())
+		return lm.pingInterval
+	}
+
+	if res.Header.Get(qpsSubscribedHeader) == "true" {
+		lm.collect()
+		lm.subscribed.Store(true)
+		return lm.postInterval
+	}
+	if hint := res.Header.Get(qpsPollingIntervalHeader); hint != "" {
+		if ms, err := strconv.Atoi(hint); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return lm.pingInterval
+}
+
+// post submits the metrics aggregated since the last post, and returns when
+// the service should be contacted next.
+func (lm *liveMetrics) post() time.Duration {
+	res, err := lm.send("post", []liveDataPoint{lm.dataPoint(lm.collect())})
+	if err != nil {
+		lm.log("Live metrics post failed: " + err.Error())
+		lm.subscribed.Store(false)
+		return lm.pingInterval
+	}
+
+	if res.Header.Get(qpsSubscribedHeader) != "true" {
+		lm.subscribed.Store(false)
+		return lm.pingInterval
+	}
+	return lm.postInterval
+}
+
+// send posts the body to the method of the live metrics service, following
+// endpoint redirects issued by the service.
+func (lm *liveMetrics) send(method string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), liveTimeout)
+	defer cancel()
+
+	u := lm.endpoint + "/QuickPulseService.svc/" + method +
+		"?ikey=" + url.QueryEscape(lm.ikey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(qpsStreamIdHeader, lm.streamId)
+	req.Header.Set(qpsMachineNameHeader, lm.machine)
+	req.Header.Set(qpsInstanceNameHeader, lm.machine)
+	req.Header.Set(qpsRoleNameHeader, lm.roleName())
+	req.Header.Set(qpsInvariantVersionHeader, "1")
+	req.Header.Set(qpsTransmissionTimeHeader, strconv.FormatInt(ticks(time.Now()), 10))
+	if lm.tokens != nil {
+		token, err := lm.tokens.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := lm.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		if lm.tokens != nil && (res.StatusCode == http.StatusUnauthorized ||
+			res.StatusCode == http.StatusForbidden) {
+			lm.tokens.invalidate()
+		}
+		return nil, errors.New("live metrics service responded " + res.Status)
+	}
+	if redirect := res.Header.Get(qpsRedirectHeader); redirect != "" {
+		if validateURL(redirect) == nil {
+			lm.endpoint = redirect
+		}
+	}
+	return res, nil
+}
+
+// setRole sets the cloud role that the instance reports, which is the
+// service name of the resource of exported spans.
+func (lm *liveMetrics) setRole(role string) {
+	lm.mtx.Lock()
+	lm.role = role
+	lm.mtx.Unlock()
+}
+
+// roleName returns the cloud role that the instance reports.
+func (lm *liveMetrics) roleName() string {
+	lm.mtx.Lock()
+	defer lm.mtx.Unlock()
+	return lm.role
+}
+
+// track aggregates the telemetry while a viewer is subscribed.
+func (lm *liveMetrics) track(tele appinsights.Telemetry) {
+	if !lm.subscribed.Load() {
+		return
+	}
+
+	lm.mtx.Lock()
+	defer lm.mtx.Unlock()
+	switch t := tele.(type) {
+	case *appinsights.RequestTelemetry:
+		lm.acc.requests++
+		lm.acc.requestDuration += t.Duration
+		if !t.Success {
+			lm.acc.requestsFailed++
+		}
+	case *appinsights.RemoteDependencyTelemetry:
+		lm.acc.dependencies++
+		lm.acc.dependencyDuration += t.Duration
+		if !t.Success {
+			lm.acc.dependenciesFailed++
+		}
+	case *appinsights.ExceptionTelemetry, *exceptionTelemetry:
+		lm.acc.exceptions++
+	}
+}
+
+// collect computes the metrics aggregated since the last collection and
+// starts a new aggregation.
+func (lm *liveMetrics) collect() []liveMetric {
+	lm.mtx.Lock()
+	acc := lm.acc
+	lm.acc = liveAccumulator{start: time.Now()}
+	lm.mtx.Unlock()
+
+	secs := time.Since(acc.start).Seconds()
+	if secs <= 0 {
+		secs = 1
+	}
+	rate := func(n int) float64 { return float64(n) / secs }
+	avg := func(d time.Duration, n int) float64 {
+		if n == 0 {
+			return 0
+		}
+		return float64(d.Milliseconds()) / float64(n)
+	}
+
+	return []liveMetric{
+		{Name: qpsRequestRate, Value: rate(acc.requests), Weight: 1},
+		{Name: qpsRequestDuration, Value: avg(acc.requestDuration, acc.requests), Weight: acc.requests},
+		{Name: qpsRequestFailedRate, Value: rate(acc.requestsFailed), Weight: 1},
+		{Name: qpsRequestSucceededRate, Value: rate(acc.requests - acc.requestsFailed), Weight: 1},
+		{Name: qpsDependencyRate, Value: rate(acc.dependencies), Weight: 1},
+		{Name: qpsDependencyDuration, Value: avg(acc.dependencyDuration, acc.dependencies), Weight: acc.dependencies},
+		{Name: qpsDependencyFailedRate, Value: rate(acc.dependenciesFailed), Weight: 1},
+		{Name: qpsDependencySucceedRate, Value: rate(acc.dependencies - acc.dependenciesFailed), Weight: 1},
+		{Name: qpsExceptionRate, Value: rate(acc.exceptions), Weight: 1},
+	}
+}
+
+// dataPoint creates a data point describing this instance with the metrics.
+func (lm *liveMetrics) dataPoint(metrics []liveMetric) liveDataPoint {
+	return liveDataPoint{
+		Version:          sdkVersion,
+		InvariantVersion: 1,
+		Instance:         lm.machine,
+		RoleName:         lm.roleName(),
+		MachineName:      lm.machine,
+		StreamId:         lm.streamId,
+		Timestamp:        "/Date(" + strconv.FormatInt(time.Now().UnixMilli(), 10) + ")/",
+		Metrics:          metrics,
+	}
+}
+
+// log writes a message to the logger if there is one.
+func (lm *liveMetrics) log(msg string) {
+	if lm.logger != nil {
+		lm.logger(msg)
+	}
+}
+
+// ticks converts the time to .NET ticks, the number of 100 nanosecond
+// intervals since the first of January of year 1.
+func ticks(t time.Time) int64 {
+	return t.UnixNano()/100 + 621355968000000000
+}
@@ -0,0 +1,222 @@
+package apex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// mockQuickPulse is a fake live metrics service that subscribes after a
+// number of pings and records the posted data points.
+type mockQuickPulse struct {
+	*httptest.Server
+	mtx       sync.Mutex
+	pings     int
+	subscribe int
+	points    []liveDataPoint
+	headers   []http.Header
+	queries   []string
+	leave     bool
+}
+
+// newMockQuickPulse creates a fake live metrics service that subscribes to
+// the stream on the given ping.
+func newMockQuickPulse(subscribe int) *mockQuickPulse {
+	qp := &mockQuickPulse{subscribe: subscribe}
+	qp.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			qp.mtx.Lock()
+			defer qp.mtx.Unlock()
+			qp.headers = append(qp.headers, r.Header.Clone())
+			qp.queries = append(qp.queries, r.URL.RawQuery)
+
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/QuickPulseService.svc/ping"):
+				qp.pings++
+				point := liveDataPoint{}
+				json.NewDecoder(r.Body).Decode(&point)
+				if qp.pings >= qp.subscribe {
+					w.Header().Set(qpsSubscribedHeader, "true")
+				} else {
+					w.Header().Set(qpsSubscribedHeader, "false")
+				}
+			case strings.HasSuffix(r.URL.Path, "/QuickPulseService.svc/post"):
+				points := []liveDataPoint{}
+				json.NewDecoder(r.Body).Decode(&points)
+				qp.points = append(qp.points, points...)
+				if qp.leave {
+					w.Header().Set(qpsSubscribedHeader, "false")
+				} else {
+					w.Header().Set(qpsSubscribedHeader, "true")
+				}
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		},
+	))
+	return qp
+}
+
+// posted returns the data points posted so far.
+func (qp *mockQuickPulse) posted() []liveDataPoint {
+	qp.mtx.Lock()
+	defer qp.mtx.Unlock()
+	return append([]liveDataPoint{}, qp.points...)
+}
+
+// metric finds the value of a metric in the data point.
+func metric(point liveDataPoint, name string) float64 {
+	for _, m := range point.Metrics {
+		if m.Name == name {
+			return m.Value
+		}
+	}
+	return -1
+}
+
+// TestLiveMetricsProtocol tests that the live metrics client pings until it
+// is subscribed, posts metrics while subscribed and pings again afterwards
+func TestLiveMetricsProtocol(t *testing.T) {
+	qp := newMockQuickPulse(3)
+	defer qp.Close()
+
+	lm := newLiveMetrics(qp.URL, "key", "checkout", http.DefaultClient, nil, nil)
+	lm.pingInterval = 10 * time.Millisecond
+	lm.postInterval = 10 * time.Millisecond
+	lm.start()
+	defer lm.stop()
+
+	assert.Eventually(t, lm.subscribed.Load, time.Second, time.Millisecond)
+	lm.track(&appinsights.RequestTelemetry{Duration: 20 * time.Millisecond, Success: true})
+	lm.track(&appinsights.RequestTelemetry{Duration: 40 * time.Millisecond})
+	lm.track(&appinsights.RemoteDependencyTelemetry{Duration: 10 * time.Millisecond})
+	lm.track(&appinsights.ExceptionTelemetry{})
+	lm.track(&exceptionTelemetry{})
+
+	var point liveDataPoint
+	assert.Eventually(t, func() bool {
+		for _, p := range qp.posted() {
+			if metric(p, qpsRequestRate) > 0 {
+				point = p
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, "checkout", point.RoleName)
+	assert.Equal(t, lm.streamId, point.StreamId)
+	assert.Equal(t, 30.0, metric(point, qpsRequestDuration))
+	assert.Greater(t, metric(point, qpsRequestFailedRate), 0.0)
+	assert.Greater(t, metric(point, qpsRequestSucceededRate), 0.0)
+	assert.Greater(t, metric(point, qpsDependencyFailedRate), 0.0)
+	assert.Equal(t, 0.0, metric(point, qpsDependencySucceedRate))
+	assert.Greater(t, metric(point, qpsExceptionRate), 0.0)
+
+	qp.mtx.Lock()
+	qp.leave = true
+	qp.subscribe = 1 << 30
+	assert.Equal(t, 3, qp.pings)
+	assert.Equal(t, "ikey=key", qp.queries[0])
+	assert.Equal(t, "checkout", qp.headers[0].Get(qpsRoleNameHeader))
+	assert.Equal(t, lm.streamId, qp.headers[0].Get(qpsStreamIdHeader))
+	assert.NotEmpty(t, qp.headers[0].Get(qpsTransmissionTimeHeader))
+	qp.mtx.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return !lm.subscribed.Load()
+	}, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		qp.mtx.Lock()
+		defer qp.mtx.Unlock()
+		return qp.pings > 3
+	}, time.Second, time.Millisecond)
+}
+
+// TestLiveMetricsNotSubscribed tests that telemetry is not aggregated while
+// no viewer is subscribed
+func TestLiveMetricsNotSubscribed(t *testing.T) {
+	lm := newLiveMetrics("http://localhost", "key", "", http.DefaultClient, nil, nil)
+	lm.track(&appinsights.RequestTelemetry{Success: true})
+	lm.track(&exceptionTelemetry{})
+
+	for _, m := range lm.collect() {
+		assert.Equal(t, 0.0, m.Value)
+	}
+}
+
+// TestExportLiveMetrics tests that exported spans are streamed to the live
+// endpoint of the connection string, with the service name of their resource
+// as the role
+func TestExportLiveMetrics(t *testing.T) {
+	tests := []struct {
+		Name string
+		Res  []attribute.KeyValue
+		Role string
+	}{
+		{
+			Name: "Resource service name",
+			Res:  []attribute.KeyValue{semconv.ServiceNameKey.String("orders")},
+			Role: "orders",
+		},
+		{
+			Name: "Configured service name",
+			Role: "checkout",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			qp := newMockQuickPulse(1)
+			defer qp.Close()
+
+			exp, err := NewExporterFromConnectionString(
+				"InstrumentationKey=key;LiveEndpoint="+qp.URL,
+				nil,
+				WithLiveMetrics(),
+				WithServiceName("checkout"),
+			)
+			assert.Nil(t, err)
+			exp.client = &mockTelemetryClient{}
+			assert.Eventually(t, exp.live.subscribed.Load, time.Second, time.Millisecond)
+
+			res, _ := resource.New(context.Background(), resource.WithAttributes(test.Res...))
+			spans := []sdktrace.ReadOnlySpan{
+				&mockSpan{
+					name:      "GET /users",
+					kind:      trace.SpanKindServer,
+					status:    sdktrace.Status{Code: codes.Ok},
+					startTime: time.Now().Add(-time.Millisecond),
+					endTime:   time.Now(),
+					res:       res,
+				},
+			}
+			assert.Nil(t, exp.ExportSpans(context.Background(), spans))
+
+			assert.Eventually(t, func() bool {
+				for _, p := range qp.posted() {
+					if metric(p, qpsRequestRate) > 0 {
+						return p.RoleName == test.Role
+					}
+				}
+				return false
+			}, 3*time.Second, 10*time.Millisecond)
+
+			assert.Nil(t, exp.Shutdown(context.Background()))
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package apex
+
+import (
+	"math"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// sample checks if the span belongs to a trace that is kept by the
+// exporter's sampling percentage.
+func (exp *AppInsightsExporter) sample(sp sdktrace.ReadOnlySpan) bool {
+	if exp.sampling >= 100 {
+		return true
+	}
+	return samplingScore(operationId(sp.SpanContext())) < exp.sampling
+}
+
+// samplingScore computes a score between 0 and 100 from the operation id
+// with the hash used by Application Insights SDKs, so that services sampling
+// with different SDKs keep the same traces.
+func samplingScore(id string) float64 {
+	if id == "" {
+		return 0
+	}
+	for len(id) < 8 {
+		id += id
+	}
+
+	var hash int32 = 5381
+	for i := 0; i < len(id); i++ {
+		hash = (hash << 5) + hash + int32(id[i])
+	}
+	if hash == math.MinInt32 {
+		hash = math.MaxInt32
+	}
+	return math.Abs(float64(hash)) / math.MaxInt32 * 100
+}
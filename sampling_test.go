@@ -0,0 +1,91 @@
+package apex
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestSamplingScore tests that sampling scores are deterministic and within
+// the range of percentages
+func TestSamplingScore(t *testing.T) {
+	ids := []string{
+		"",
+		"a",
+		"abc",
+		"0af7651916cd43dd8448eb211c80319c",
+		"4bf92f3577b34da6a3ce929d0e0e4736",
+	}
+
+	for _, id := range ids {
+		score := samplingScore(id)
+		assert.GreaterOrEqual(t, score, 0.0)
+		assert.LessOrEqual(t, score, 100.0)
+		assert.Equal(t, score, samplingScore(id))
+	}
+	assert.Equal(t, samplingScore("abcd"), samplingScore("abcdabcd"))
+}
+
+// TestExportSpansSampled tests that spans are sampled in proportion to the
+// sampling percentage, and that sampled out spans are counted
+func TestExportSpansSampled(t *testing.T) {
+	res, _ := resource.New(context.Background())
+	spans := make([]sdktrace.ReadOnlySpan, 1000)
+	for i := range spans {
+		sp := &mockSpan{
+			name:   "GET /users",
+			kind:   trace.SpanKindServer,
+			status: sdktrace.Status{Code: codes.Ok},
+			res:    res,
+		}
+		rand.Read(sp.traceId[:])
+		spans[i] = sp
+	}
+
+	tests := []struct {
+		Name       string
+		Percentage float64
+		Min        int
+		Max        int
+	}{
+		{Name: "Everything", Percentage: 100, Min: 1000, Max: 1000},
+		{Name: "Nothing", Percentage: 0, Min: 0, Max: 0},
+		{Name: "Half", Percentage: 50, Min: 400, Max: 600},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, err := NewExporter("", nil, WithSamplingPercentage(test.Percentage))
+			assert.Nil(t, err)
+			exp.client = tcl
+
+			err = exp.ExportSpans(context.Background(), spans)
+			assert.Nil(t, err)
+
+			kept := len(tcl.tels)
+			assert.GreaterOrEqual(t, kept, test.Min)
+			assert.LessOrEqual(t, kept, test.Max)
+			assert.Equal(t, uint64(1000-kept), exp.Stats().SpansSampledOut)
+		})
+	}
+
+	again := &mockTelemetryClient{}
+	exp, _ := NewExporter("", nil, WithSamplingPercentage(50))
+	exp.client = again
+	exp.ExportSpans(context.Background(), spans)
+	first := &mockTelemetryClient{}
+	exp, _ = NewExporter("", nil, WithSamplingPercentage(50))
+	exp.client = first
+	exp.ExportSpans(context.Background(), spans)
+	assert.Equal(t, len(first.tels), len(again.tels))
+
+	_, err := NewExporter("", nil, WithSamplingPercentage(101))
+	assert.NotNil(t, err)
+}
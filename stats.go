@@ -0,0 +1,127 @@
+package apex
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the counters the exporter keeps about its own
+// operation since it was created.
+type Stats struct {
+	// SpansReceived is the number of spans submitted to the exporter.
+	SpansReceived uint64
+	// SpansFiltered is the number of spans dropped by filters.
+	SpansFiltered uint64
+	// SpansSampledOut is the number of spans dropped by sampling.
+	SpansSampledOut uint64
+
+	// EventsTracked is the number of event telemetry items tracked.
+	EventsTracked uint64
+	// RequestsTracked is the number of request telemetry items tracked.
+	RequestsTracked uint64
+	// DependenciesTracked is the number of dependency telemetry items
+	// tracked.
+	DependenciesTracked uint64
+	// ExceptionsTracked is the number of exception telemetry items tracked.
+	ExceptionsTracked uint64
+	// AvailabilityTracked is the number of availability telemetry items
+	// tracked.
+	AvailabilityTracked uint64
+	// PageViewsTracked is the number of page view telemetry items tracked.
+	PageViewsTracked uint64
+
+	// DroppedAttributes is the total number of attributes that exported
+	// spans dropped due to span limits.
+	DroppedAttributes uint64
+	// DroppedEvents is the total number of events that exported spans
+	// dropped due to span limits.
+	DroppedEvents uint64
+	// DroppedLinks is the total number of links that exported spans dropped
+	// due to span limits.
+	DroppedLinks uint64
+
+	// ItemsSent is the number of telemetry items accepted by the ingestion
+	// endpoint.
+	ItemsSent uint64
+	// ItemsRejected is the number of telemetry items the ingestion endpoint
+	// rejected permanently, which are not sent again.
+	ItemsRejected uint64
+	// BatchesDropped is the number of batches discarded after their retries
+	// were exhausted, without being persisted in the offline storage.
+	BatchesDropped uint64
+	// BatchesPersisted is the number of batches saved in the offline storage
+	// after their retries were exhausted.
+	BatchesPersisted uint64
+
+	// Exports is the number of successful calls to ExportSpans.
+	Exports uint64
+	// ExportErrors is the number of calls to ExportSpans that failed.
+	ExportErrors uint64
+	// ExportTime is the total time spent processing spans in ExportSpans.
+	ExportTime time.Duration
+	// LastExportTime is the time spent processing spans in the latest
+	// successful call to ExportSpans.
+	LastExportTime time.Duration
+}
+
+// counters holds the live values of the exporter's statistics.
+type counters struct {
+	spansReceived   atomic.Uint64
+	spansFiltered   atomic.Uint64
+	spansSampledOut atomic.Uint64
+
+	eventsTracked       atomic.Uint64
+	requestsTracked     atomic.Uint64
+	dependenciesTracked atomic.Uint64
+	exceptionsTracked   atomic.Uint64
+	availabilityTracked atomic.Uint64
+	pageViewsTracked    atomic.Uint64
+
+	droppedAttributes atomic.Uint64
+	droppedEvents     atomic.Uint64
+	droppedLinks      atomic.Uint64
+
+	exports        atomic.Uint64
+	exportErrors   atomic.Uint64
+	exportTime     atomic.Int64
+	lastExportTime atomic.Int64
+}
+
+// observeExport records a successful export that started at the given time.
+func (c *counters) observeExport(start time.Time) {
+	dur := int64(time.Since(start))
+	c.exports.Add(1)
+	c.exportTime.Add(dur)
+	c.lastExportTime.Store(dur)
+}
+
+// Stats returns a snapshot of the exporter's statistics. The statistics of
+// transmitted items are only kept when the exporter sends telemetry with its
+// own channel.
+func (exp *AppInsightsExporter) Stats() Stats {
+	stats := Stats{
+		SpansReceived:       exp.counters.spansReceived.Load(),
+		SpansFiltered:       exp.counters.spansFiltered.Load(),
+		SpansSampledOut:     exp.counters.spansSampledOut.Load(),
+		EventsTracked:       exp.counters.eventsTracked.Load(),
+		RequestsTracked:     exp.counters.requestsTracked.Load(),
+		DependenciesTracked: exp.counters.dependenciesTracked.Load(),
+		ExceptionsTracked:   exp.counters.exceptionsTracked.Load(),
+		AvailabilityTracked: exp.counters.availabilityTracked.Load(),
+		PageViewsTracked:    exp.counters.pageViewsTracked.Load(),
+		DroppedAttributes:   exp.counters.droppedAttributes.Load(),
+		DroppedEvents:       exp.counters.droppedEvents.Load(),
+		DroppedLinks:        exp.counters.droppedLinks.Load(),
+		Exports:             exp.counters.exports.Load(),
+		ExportErrors:        exp.counters.exportErrors.Load(),
+		ExportTime:          time.Duration(exp.counters.exportTime.Load()),
+		LastExportTime:      time.Duration(exp.counters.lastExportTime.Load()),
+	}
+	if ch, ok := exp.client.Channel().(*batchChannel); ok {
+		stats.ItemsSent = ch.counters.itemsSent.Load()
+		stats.ItemsRejected = ch.counters.itemsRejected.Load()
+		stats.BatchesDropped = ch.counters.batchesDropped.Load()
+		stats.BatchesPersisted = ch.counters.batchesPersisted.Load()
+	}
+	return stats
+}
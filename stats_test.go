@@ -0,0 +1,61 @@
+package apex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestStats tests that the exporter counts the spans it receives and the
+// telemetry it tracks
+func TestStats(t *testing.T) {
+	tcl := &mockTelemetryClient{}
+	exp, _ := NewExporter(
+		"", nil,
+		WithFilters(Filter{Names: []string{"filtered"}}),
+		WithStatusExceptions(),
+	)
+	exp.client = tcl
+
+	res, _ := resource.New(context.Background())
+	newSpan := func(name string, kind trace.SpanKind, code codes.Code) *mockSpan {
+		return &mockSpan{
+			name:   name,
+			kind:   kind,
+			status: sdktrace.Status{Code: code},
+			res:    res,
+			attr:   []attribute.KeyValue{},
+		}
+	}
+
+	spans := []sdktrace.ReadOnlySpan{
+		newSpan("filtered", trace.SpanKindServer, codes.Ok),
+		newSpan("internal", trace.SpanKindInternal, codes.Ok),
+		newSpan("request", trace.SpanKindServer, codes.Ok),
+		newSpan("event", trace.SpanKindConsumer, codes.Ok),
+		newSpan("dependency", trace.SpanKindClient, codes.Error),
+		newSpan("producer", trace.SpanKindProducer, codes.Ok),
+	}
+
+	assert.Nil(t, exp.ExportSpans(context.Background(), spans))
+	exp.closed = true
+	assert.NotNil(t, exp.ExportSpans(context.Background(), spans[:1]))
+
+	stats := exp.Stats()
+	assert.Equal(t, uint64(7), stats.SpansReceived)
+	assert.Equal(t, uint64(1), stats.SpansFiltered)
+	assert.Equal(t, uint64(1), stats.EventsTracked)
+	assert.Equal(t, uint64(2), stats.RequestsTracked)
+	assert.Equal(t, uint64(2), stats.DependenciesTracked)
+	assert.Equal(t, uint64(1), stats.ExceptionsTracked)
+	assert.Equal(t, uint64(1), stats.Exports)
+	assert.Equal(t, uint64(1), stats.ExportErrors)
+	assert.Equal(t, stats.ExportTime, stats.LastExportTime)
+	assert.Equal(t, 6, len(tcl.tels))
+}
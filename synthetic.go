@@ -0,0 +1,83 @@
+package apex
+
+import (
+	"strings"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// requestHeaderPrefix precedes the names of request headers captured as
+// attributes by http server instrumentations.
+const requestHeaderPrefix = "http.request.header."
+
+// Attributes of request headers that identify the caller of a request.
+const (
+	requestContextRequestAttribute = requestHeaderPrefix + "request-context"
+	syntheticRunIdAttribute        = requestHeaderPrefix + "synthetictest-runid"
+	syntheticLocationAttribute     = requestHeaderPrefix + "synthetictest-location"
+)
+
+// Synthetic sources of requests made by availability tests and bots.
+const (
+	availabilitySource = "Application Insights Availability Monitoring"
+	botSource          = "Bot"
+)
+
+// defaultSyntheticUserAgents are fragments of the user agents of search
+// engines, crawlers and monitoring services, matched case insensitively.
+var defaultSyntheticUserAgents = []string{
+	"search",
+	"spider",
+	"crawl",
+	"bot",
+	"monitor",
+	"alwayson",
+}
+
+// headerAttribute returns the attribute of a captured request header.
+func headerAttribute(header string) string {
+	return requestHeaderPrefix + strings.ToLower(header)
+}
+
+// requestSource returns the source of a request, which is the application
+// id of the caller from its Request-Context header, prefixed like other
+// Application Insights SDKs do.
+func requestSource(properties map[string]string) string {
+	val, ok := properties[requestContextRequestAttribute]
+	if !ok {
+		return ""
+	}
+	delete(properties, requestContextRequestAttribute)
+	if appId := parseRequestContext(val); appId != "" {
+		return appIdPrefix + appId
+	}
+	return ""
+}
+
+// syntheticSource detects requests made by availability tests, by the
+// configured headers or by user agents of bots, and returns the source
+// of the synthetic traffic. Regular requests have no synthetic source.
+func (exp *AppInsightsExporter) syntheticSource(
+	sp sdktrace.ReadOnlySpan,
+	properties map[string]string,
+) string {
+	if _, ok := properties[syntheticRunIdAttribute]; ok {
+		return availabilitySource
+	}
+	for _, header := range exp.syntheticHeaders {
+		if _, ok := properties[headerAttribute(header)]; ok {
+			return header
+		}
+	}
+	agent := strings.ToLower(spanAttribute(sp, semconv.HTTPUserAgentKey))
+	if agent == "" {
+		return ""
+	}
+	for _, fragment := range exp.syntheticAgents {
+		if strings.Contains(agent, fragment) {
+			return botSource
+		}
+	}
+	return ""
+}
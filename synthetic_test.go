@@ -0,0 +1,134 @@
+package apex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	trace "go.opentelemetry.io/otel/trace"
+)
+
+// TestProcessSynthetic tests that requests get their source from the
+// caller's application id and a synthetic source when they are made by
+// availability tests, bots or clients sending configured headers
+func TestProcessSynthetic(t *testing.T) {
+	tests := []struct {
+		Name string
+		Opts []Option
+		Attr []attribute.KeyValue
+
+		TelSource     string
+		TelSynthetic  string
+		TelSessionId  string
+		TelUserId     string
+		TelProperties []string
+	}{
+		{
+			Name: "Regular request",
+			Attr: []attribute.KeyValue{
+				semconv.HTTPUserAgentKey.String("Mozilla/5.0 (X11; Linux x86_64)"),
+			},
+		},
+		{
+			Name: "Caller application id",
+			Attr: []attribute.KeyValue{
+				attribute.String(
+					"http.request.header.request-context",
+					"appId=cid-v1:caller-app",
+				),
+			},
+			TelSource: "cid-v1:caller-app",
+		},
+		{
+			Name: "Request context without application id",
+			Attr: []attribute.KeyValue{
+				attribute.String(
+					"http.request.header.request-context",
+					"roleName=frontend",
+				),
+			},
+			TelSource: "",
+		},
+		{
+			Name: "Availability test",
+			Attr: []attribute.KeyValue{
+				attribute.String("http.request.header.synthetictest-runid", "run-1"),
+				attribute.String("http.request.header.synthetictest-location", "emea-nl-ams-azr"),
+			},
+			TelSynthetic: "Application Insights Availability Monitoring",
+			TelSessionId: "run-1",
+			TelUserId:    "emea-nl-ams-azr",
+			TelProperties: []string{
+				"http.request.header.synthetictest-runid",
+				"http.request.header.synthetictest-location",
+			},
+		},
+		{
+			Name: "Search engine crawler",
+			Attr: []attribute.KeyValue{
+				semconv.HTTPUserAgentKey.String(
+					"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+				),
+			},
+			TelSynthetic: "Bot",
+		},
+		{
+			Name: "Configured user agent",
+			Opts: []Option{WithSyntheticUserAgents("K6")},
+			Attr: []attribute.KeyValue{
+				semconv.HTTPUserAgentKey.String("k6/0.42.0 (https://k6.io/)"),
+			},
+			TelSynthetic: "Bot",
+		},
+		{
+			Name: "Configured header",
+			Opts: []Option{WithSyntheticHeaders("X-Load-Test")},
+			Attr: []attribute.KeyValue{
+				attribute.String("http.request.header.x-load-test", "1"),
+			},
+			TelSynthetic:  "X-Load-Test",
+			TelProperties: []string{"http.request.header.x-load-test"},
+		},
+		{
+			Name: "Header not configured",
+			Attr: []attribute.KeyValue{
+				attribute.String("http.request.header.x-load-test", "1"),
+			},
+			TelSynthetic: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tcl := &mockTelemetryClient{}
+			exp, _ := NewExporter("", nil, test.Opts...)
+			exp.client = tcl
+
+			res, _ := resource.New(context.Background())
+			exp.process(&mockSpan{
+				name:   "GET /",
+				kind:   trace.SpanKindServer,
+				status: sdktrace.Status{Code: codes.Ok},
+				res:    res,
+				attr:   test.Attr,
+			})
+
+			assert.Equal(t, 1, len(tcl.tels))
+			tel := tcl.tels[0].(*appinsights.RequestTelemetry)
+			assert.Equal(t, test.TelSource, tel.Source)
+			assert.Equal(t, test.TelSynthetic, tel.Tags.Operation().GetSyntheticSource())
+			assert.Equal(t, test.TelSessionId, tel.Tags.Session().GetId())
+			assert.Equal(t, test.TelUserId, tel.Tags.User().GetId())
+			assert.NotContains(t, tel.Properties, "http.request.header.request-context")
+			for _, key := range test.TelProperties {
+				assert.Contains(t, tel.Properties, key)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package apex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEndpoint is the ingestion endpoint telemetry is sent to when no
+// other endpoint is configured.
+const defaultEndpoint = "https://dc.services.visualstudio.com/v2.1/track"
+
+// transmitter sends compressed batches of serialized telemetry items to the
+// ingestion endpoint.
+type transmitter struct {
+	endpoint string
+	client   *http.Client
+	tokens   *tokenCache
+}
+
+// transmission is the outcome of sending a batch to the ingestion endpoint.
+type transmission struct {
+	statusCode int
+	authFailed bool
+	retryAfter time.Time
+	response   *ingestionResponse
+}
+
+// ingestionResponse is the body of the ingestion endpoint's response.
+type ingestionResponse struct {
+	ItemsReceived int              `json:"itemsReceived"`
+	ItemsAccepted int              `json:"itemsAccepted"`
+	Errors        []ingestionError `json:"errors"`
+}
+
+// ingestionError describes why an item of the batch was rejected.
+type ingestionError struct {
+	Index      int    `json:"index"`
+	StatusCode int    `json:"statusCode"`
+	Message    string `json:"message"`
+}
+
+// transmit posts the compressed batch to the ingestion endpoint and parses
+// the response, which may be compressed. If the transmitter has a token source, the request is
+// authenticated with a bearer token, which is discarded if it is rejected.
+func (t *transmitter) transmit(
+	ctx context.Context,
+	payload []byte,
+) (*transmission, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, t.endpoint, bytes.NewReader(payload),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "application/x-json-stream")
+
+	if t.tokens != nil {
+		tok, err := t.tokens.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := readBody(res)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &transmission{
+		statusCode: res.StatusCode,
+		retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+	if t.tokens != nil && (res.StatusCode == http.StatusUnauthorized ||
+		res.StatusCode == http.StatusForbidden) {
+		t.tokens.invalidate()
+		tr.authFailed = true
+	}
+	ir := &ingestionResponse{}
+	if err := json.Unmarshal(body, ir); err == nil {
+		tr.response = ir
+	}
+	return tr, nil
+}
+
+// readBody reads the body of the response, decompressing it if it is
+// compressed with gzip and the http client did not decompress it already.
+func readBody(res *http.Response) ([]byte, error) {
+	if !res.Uncompressed &&
+		strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// retryItems returns the items of the batch that failed transiently and can
+// be sent again.
+func (tr *transmission) retryItems(items [][]byte) [][]byte {
+	if tr.failed() {
+		return items
+	}
+	if tr.statusCode != http.StatusPartialContent || tr.response == nil {
+		return nil
+	}
+
+	retry := [][]byte{}
+	for _, e := range tr.response.Errors {
+		if e.Index >= 0 && e.Index < len(items) && isTransientStatus(e.StatusCode) {
+			retry = append(retry, items[e.Index])
+		}
+	}
+	return retry
+}
+
+// rejectedItems returns the number of items of the batch of the given size
+// that the ingestion endpoint rejected permanently, which are not sent again,
+// and the reasons the endpoint gave for rejecting them.
+func (tr *transmission) rejectedItems(count int) (int, []string) {
+	if tr.failed() || tr.statusCode < http.StatusMultipleChoices &&
+		tr.statusCode != http.StatusPartialContent {
+		return 0, nil
+	}
+
+	reasons := []string{}
+	rejected := 0
+	if tr.response != nil {
+		for _, e := range tr.response.Errors {
+			if tr.statusCode == http.StatusPartialContent &&
+				isTransientStatus(e.StatusCode) {
+				continue
+			}
+			rejected++
+			reasons = append(reasons, fmt.Sprintf(
+				"item %d: %d %s", e.Index, e.StatusCode, e.Message,
+			))
+		}
+	}
+	if tr.statusCode != http.StatusPartialContent {
+		rejected = count
+	}
+	return rejected, reasons
+}
+
+// failed checks if the whole batch failed with an error that might not occur
+// when the batch is sent again later.
+func (tr *transmission) failed() bool {
+	return tr.authFailed || isTransientStatus(tr.statusCode)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given either in
+// seconds or as a date. The zero time is returned if the value is invalid.
+func parseRetryAfter(val string) time.Time {
+	if val == "" {
+		return time.Time{}
+	}
+	if secs, err := strconv.Atoi(val); err == nil && secs >= 0 {
+		return time.Now().Add(time.Duration(secs) * time.Second)
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// isTransientStatus checks if the ingestion response status code indicates a
+// failure that might succeed when retried later.
+func isTransientStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		439,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// compress joins the serialized items into a newline delimited stream and
+// compresses it with gzip.
+func compress(items [][]byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+	for _, item := range items {
+		if _, err := zw.Write(item); err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write([]byte{'\n'}); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress splits a compressed newline delimited stream into the
+// serialized items it contains.
+func decompress(payload []byte) ([][]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	items := [][]byte{}
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			items = append(items, append([]byte{}, sc.Bytes()...))
+		}
+	}
+	return items, sc.Err()
+}
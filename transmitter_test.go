@@ -0,0 +1,259 @@
+package apex
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCompress tests that serialized items survive compression
+func TestCompress(t *testing.T) {
+	items := [][]byte{
+		[]byte(`{"name":"first"}`),
+		[]byte(`{"name":"second"}`),
+	}
+
+	payload, err := compress(items)
+	assert.Nil(t, err)
+
+	out, err := decompress(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, items, out)
+
+	_, err = decompress([]byte("not compressed"))
+	assert.NotNil(t, err)
+}
+
+// TestParseRetryAfter tests that Retry-After headers are parsed in both of
+// their formats
+func TestParseRetryAfter(t *testing.T) {
+	assert.True(t, parseRetryAfter("").IsZero())
+	assert.True(t, parseRetryAfter("soon").IsZero())
+
+	secs := parseRetryAfter("30")
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), secs, time.Second)
+
+	date := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	assert.Equal(t, date, parseRetryAfter(date.Format(http.TimeFormat)).UTC())
+}
+
+// TestRetryItems tests that only items that failed transiently are retried
+func TestRetryItems(t *testing.T) {
+	items := [][]byte{[]byte("0"), []byte("1"), []byte("2")}
+
+	tests := []struct {
+		Name   string
+		Result transmission
+		Retry  [][]byte
+	}{
+		{
+			Name:   "Success",
+			Result: transmission{statusCode: 200},
+			Retry:  nil,
+		},
+		{
+			Name:   "Bad request",
+			Result: transmission{statusCode: 400},
+			Retry:  nil,
+		},
+		{
+			Name:   "Service unavailable",
+			Result: transmission{statusCode: 503},
+			Retry:  items,
+		},
+		{
+			Name:   "Throttled",
+			Result: transmission{statusCode: 429},
+			Retry:  items,
+		},
+		{
+			Name: "Partial success",
+			Result: transmission{
+				statusCode: 206,
+				response: &ingestionResponse{
+					ItemsReceived: 3,
+					ItemsAccepted: 1,
+					Errors: []ingestionError{
+						{Index: 0, StatusCode: 400, Message: "invalid"},
+						{Index: 2, StatusCode: 500, Message: "internal"},
+						{Index: 7, StatusCode: 500, Message: "internal"},
+					},
+				},
+			},
+			Retry: [][]byte{[]byte("2")},
+		},
+		{
+			Name:   "Partial success without body",
+			Result: transmission{statusCode: 206},
+			Retry:  nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Retry, test.Result.retryItems(items))
+		})
+	}
+}
+
+// TestRejectedItems tests that items rejected permanently are counted with
+// the reasons given by the ingestion endpoint
+func TestRejectedItems(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Result   transmission
+		Rejected int
+		Reasons  []string
+	}{
+		{
+			Name:     "Success",
+			Result:   transmission{statusCode: 200},
+			Rejected: 0,
+		},
+		{
+			Name:     "Service unavailable",
+			Result:   transmission{statusCode: 503},
+			Rejected: 0,
+		},
+		{
+			Name:     "Not found",
+			Result:   transmission{statusCode: 404},
+			Rejected: 3,
+			Reasons:  []string{},
+		},
+		{
+			Name: "Bad request",
+			Result: transmission{
+				statusCode: 400,
+				response: &ingestionResponse{
+					Errors: []ingestionError{
+						{Index: 1, StatusCode: 400, Message: "invalid schema"},
+					},
+				},
+			},
+			Rejected: 3,
+			Reasons:  []string{"item 1: 400 invalid schema"},
+		},
+		{
+			Name: "Partial success",
+			Result: transmission{
+				statusCode: 206,
+				response: &ingestionResponse{
+					Errors: []ingestionError{
+						{Index: 0, StatusCode: 400, Message: "invalid"},
+						{Index: 2, StatusCode: 500, Message: "internal"},
+					},
+				},
+			},
+			Rejected: 1,
+			Reasons:  []string{"item 0: 400 invalid"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			rejected, reasons := test.Result.rejectedItems(3)
+			assert.Equal(t, test.Rejected, rejected)
+			assert.Equal(t, test.Reasons, reasons)
+		})
+	}
+}
+
+// TestTransmit tests that batches are posted compressed to the endpoint and
+// the response is parsed
+func TestTransmit(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(`{"itemsReceived":2,"itemsAccepted":1,"errors":[` +
+				`{"index":1,"statusCode":503,"message":"unavailable"}]}`))
+		},
+	)
+	defer srv.Close()
+
+	payload, _ := compress([][]byte{
+		[]byte(`{"name":"first"}`),
+		[]byte(`{"name":"second"}`),
+	})
+
+	tr := &transmitter{endpoint: srv.URL + "/v2.1/track", client: http.DefaultClient}
+	res, err := tr.transmit(context.Background(), payload)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPartialContent, res.statusCode)
+	assert.False(t, res.retryAfter.IsZero())
+	assert.Equal(t, 2, res.response.ItemsReceived)
+	assert.Equal(t, 1, res.response.ItemsAccepted)
+	assert.Equal(t, 1, len(res.response.Errors))
+
+	req := srv.requests[0]
+	assert.Equal(t, "/v2.1/track", req.URL.Path)
+	assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+	assert.Equal(t, "application/x-json-stream", req.Header.Get("Content-Type"))
+
+	batch := srv.received()[0]
+	assert.Equal(t, 2, len(batch))
+	assert.Equal(t, "first", batch[0]["name"])
+	assert.Equal(t, "second", batch[1]["name"])
+
+	tr.endpoint = "http://127.0.0.1:0"
+	_, err = tr.transmit(context.Background(), payload)
+	assert.NotNil(t, err)
+}
+
+// TestTransmitCompressedResponse tests that responses compressed with gzip
+// are parsed, whether or not the http client decompresses them
+func TestTransmitCompressedResponse(t *testing.T) {
+	srv := newMockIngestion(
+		func(w http.ResponseWriter, n int, items []map[string]interface{}) {
+			body, _ := compress([][]byte{[]byte(
+				`{"itemsReceived":2,"itemsAccepted":1,"errors":[` +
+					`{"index":1,"statusCode":503,"message":"unavailable"}]}`,
+			)})
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(body)
+		},
+	)
+	defer srv.Close()
+
+	tests := []struct {
+		Name   string
+		Client *http.Client
+	}{
+		{
+			Name:   "Decompressed by the client",
+			Client: http.DefaultClient,
+		},
+		{
+			Name: "Compression disabled",
+			Client: &http.Client{
+				Transport: &http.Transport{DisableCompression: true},
+			},
+		},
+	}
+
+	payload, _ := compress([][]byte{
+		[]byte(`{"name":"first"}`),
+		[]byte(`{"name":"second"}`),
+	})
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tr := &transmitter{endpoint: srv.URL, client: test.Client}
+			res, err := tr.transmit(context.Background(), payload)
+
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusPartialContent, res.statusCode)
+			if assert.NotNil(t, res.response) {
+				assert.Equal(t, 1, res.response.ItemsAccepted)
+			}
+			retry := res.retryItems([][]byte{[]byte("first"), []byte("second")})
+			assert.Equal(t, [][]byte{[]byte("second")}, retry)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package apex
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// transport holds the settings of the http client used for ingestion.
+type transport struct {
+	client    *http.Client
+	roundTrip http.RoundTripper
+	proxy     string
+	rootCAs   *x509.CertPool
+	timeout   time.Duration
+}
+
+// httpClient creates the http client used for ingestion from the transport
+// settings, based on the client of the telemetry configuration. The default
+// http client is used if there is nothing to configure.
+func (t *transport) httpClient(base *http.Client) (*http.Client, error) {
+	if t.client != nil {
+		base = t.client
+	}
+	if t.roundTrip == nil && t.proxy == "" && t.rootCAs == nil && t.timeout == 0 {
+		if base == nil {
+			return http.DefaultClient, nil
+		}
+		return base, nil
+	}
+
+	client := &http.Client{}
+	if base != nil {
+		*client = *base
+	}
+	if t.roundTrip != nil {
+		client.Transport = t.roundTrip
+	}
+	if t.timeout != 0 {
+		client.Timeout = t.timeout
+	}
+	if t.proxy == "" && t.rootCAs == nil {
+		return client, nil
+	}
+
+	var ht *http.Transport
+	switch rt := client.Transport.(type) {
+	case nil:
+		ht = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		ht = rt.Clone()
+	default:
+		return nil, errors.New("proxy and root CAs require an *http.Transport")
+	}
+
+	if t.proxy != "" {
+		u, err := url.Parse(t.proxy)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, errors.New("proxy url must have a scheme and a host")
+		}
+		ht.Proxy = http.ProxyURL(u)
+	}
+	if t.rootCAs != nil {
+		if ht.TLSClientConfig == nil {
+			ht.TLSClientConfig = &tls.Config{}
+		}
+		ht.TLSClientConfig.RootCAs = t.rootCAs
+	}
+
+	client.Transport = ht
+	return client, nil
+}
@@ -0,0 +1,174 @@
+package apex
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRoundTripper struct{}
+
+func (rt *mockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, http.ErrNotSupported
+}
+
+// TestTransportHttpClient tests that http clients are created accurately
+// from the transport settings
+func TestTransportHttpClient(t *testing.T) {
+	base := &http.Client{Timeout: time.Second}
+	custom := &http.Client{Timeout: time.Minute}
+	pool := x509.NewCertPool()
+
+	tests := []struct {
+		Name      string
+		Transport transport
+		Base      *http.Client
+		Error     bool
+		Check     func(t *testing.T, c *http.Client)
+	}{
+		{
+			Name:      "Default client",
+			Transport: transport{},
+			Check: func(t *testing.T, c *http.Client) {
+				assert.Equal(t, http.DefaultClient, c)
+			},
+		},
+		{
+			Name:      "Configuration client",
+			Transport: transport{},
+			Base:      base,
+			Check: func(t *testing.T, c *http.Client) {
+				assert.Equal(t, base, c)
+			},
+		},
+		{
+			Name:      "Custom client",
+			Transport: transport{client: custom},
+			Base:      base,
+			Check: func(t *testing.T, c *http.Client) {
+				assert.Equal(t, custom, c)
+			},
+		},
+		{
+			Name:      "Custom round tripper",
+			Transport: transport{roundTrip: &mockRoundTripper{}},
+			Base:      base,
+			Check: func(t *testing.T, c *http.Client) {
+				assert.IsType(t, &mockRoundTripper{}, c.Transport)
+				assert.Equal(t, time.Second, c.Timeout)
+				assert.Nil(t, base.Transport)
+			},
+		},
+		{
+			Name:      "Request timeout",
+			Transport: transport{timeout: 5 * time.Second},
+			Base:      base,
+			Check: func(t *testing.T, c *http.Client) {
+				assert.Equal(t, 5*time.Second, c.Timeout)
+				assert.Equal(t, time.Second, base.Timeout)
+			},
+		},
+		{
+			Name:      "Proxy and root CAs",
+			Transport: transport{proxy: "http://proxy:3128", rootCAs: pool},
+			Check: func(t *testing.T, c *http.Client) {
+				ht := c.Transport.(*http.Transport)
+				req, _ := http.NewRequest(http.MethodPost, "https://example.com", nil)
+				proxy, _ := ht.Proxy(req)
+				assert.Equal(t, "http://proxy:3128", proxy.String())
+				assert.Equal(t, pool, ht.TLSClientConfig.RootCAs)
+			},
+		},
+		{
+			Name:      "Invalid proxy",
+			Transport: transport{proxy: "proxy"},
+			Error:     true,
+		},
+		{
+			Name: "Proxy with custom round tripper",
+			Transport: transport{
+				roundTrip: &mockRoundTripper{},
+				proxy:     "http://proxy:3128",
+			},
+			Error: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			c, err := test.Transport.httpClient(test.Base)
+			if test.Error {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			test.Check(t, c)
+		})
+	}
+}
+
+// TestTransportIngestion tests that ingestion requests are sent with the
+// configured root CAs, proxy and timeout
+func TestTransportIngestion(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		},
+	))
+	defer tlsSrv.Close()
+
+	proxied := atomic.Int64{}
+	proxy := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			proxied.Add(1)
+			w.WriteHeader(http.StatusOK)
+		},
+	))
+	defer proxy.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		},
+	))
+	defer slow.Close()
+
+	payload, _ := compress([][]byte{[]byte(`{}`)})
+	send := func(endpoint string, tp transport) error {
+		hc, err := tp.httpClient(nil)
+		assert.Nil(t, err)
+		tr := &transmitter{endpoint: endpoint, client: hc}
+		_, err = tr.transmit(context.Background(), payload)
+		return err
+	}
+
+	assert.NotNil(t, send(tlsSrv.URL, transport{}))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsSrv.Certificate())
+	assert.Nil(t, send(tlsSrv.URL, transport{rootCAs: pool}))
+
+	assert.Nil(t, send("http://ingestion.invalid/v2.1/track", transport{proxy: proxy.URL}))
+	assert.Equal(t, int64(1), proxied.Load())
+
+	assert.NotNil(t, send(slow.URL, transport{timeout: 50 * time.Millisecond}))
+}
+
+// TestNewExporterTransport tests that exporters are not created with invalid
+// transport settings
+func TestNewExporterTransport(t *testing.T) {
+	exp, err := NewExporter("", nil, WithProxy("http://proxy:3128"))
+	assert.Nil(t, err)
+	assert.NotNil(t, exp)
+
+	exp, err = NewExporter("", nil, WithProxy("://proxy"))
+	assert.NotNil(t, err)
+	assert.Nil(t, exp)
+}